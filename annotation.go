@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/minio/cli"
+	miniogo "github.com/minio/minio-go/v7"
+	"github.com/minio/minio/pkg/console"
+)
+
+const (
+	annotationsSkip     = "skip"
+	annotationsSidecar  = "sidecar"
+	annotationsMetadata = "metadata"
+
+	// maxAnnotationMetadata is the most bytes of base64 encoded
+	// annotations embedded as user metadata of an object, larger ones are
+	// stored as sidecar objects.
+	maxAnnotationMetadata = 2048
+)
+
+var annotationFlags = []cli.Flag{
+	cli.StringFlag{
+		Name: "annotations",
+		Usage: "how to migrate HCP custom-metadata annotations, 'skip' to leave them out, 'sidecar' to store each as an object next to its object " +
+			"or 'metadata' to embed them base64 encoded as x-amz-meta-hcp-annotation-<name> when small enough, else as sidecars",
+		Value: annotationsSkip,
+	},
+	cli.StringFlag{
+		Name:  "annotation-prefix",
+		Usage: "prefix of the names of sidecar annotation objects",
+	},
+	cli.StringFlag{
+		Name:  "annotation-suffix",
+		Usage: "suffix of the object name in the names of sidecar annotation objects, <prefix><object><suffix><annotation>.xml",
+		Value: ".annotation.",
+	},
+}
+
+var (
+	annotationMode   = annotationsSkip
+	annotationPrefix string
+	annotationSuffix string
+)
+
+// parseAnnotationFlags sets the annotation mode and sidecar names from
+// --annotations, --annotation-prefix and --annotation-suffix.
+func parseAnnotationFlags(ctx *cli.Context) error {
+	annotationMode = ctx.String("annotations")
+	annotationPrefix = ctx.String("annotation-prefix")
+	annotationSuffix = ctx.String("annotation-suffix")
+	switch annotationMode {
+	case annotationsSkip, annotationsSidecar, annotationsMetadata:
+	default:
+		return fmt.Errorf("--annotations must be one of %s, %s or %s", annotationsSkip, annotationsSidecar, annotationsMetadata)
+	}
+	if annotationMode != annotationsSkip && annotationPrefix == "" && annotationSuffix == "" {
+		return fmt.Errorf("--annotation-prefix or --annotation-suffix must be set to keep sidecar annotations apart from their objects")
+	}
+	return nil
+}
+
+// annotation is a custom-metadata annotation of an HCP object.
+type annotation struct {
+	name string
+	data []byte
+}
+
+// customMetadataInfo is the response to a request for the annotations of
+// an object with type=custom-metadata-info.
+type customMetadataInfo struct {
+	Annotations []struct {
+		Name string `xml:"name"`
+		Size int64  `xml:"size"`
+	} `xml:"annotation"`
+}
+
+// getCustomMetadata sends a GET for the custom metadata of object with
+// query to HCP.
+func (hcp *hcpBackend) getCustomMetadata(ctx context.Context, object string, query url.Values) ([]byte, error) {
+	u, err := url.Parse(namespaceURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = object
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", authToken)
+	req.Host = hostHeader
+	resp, err := hcp.Client().Do(req)
+	if debugFlag {
+		console.Println(trace(req, resp))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closeResponse(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHCPError(resp)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// ListAnnotations returns the names of the custom-metadata annotations of
+// object.
+func (hcp *hcpBackend) ListAnnotations(ctx context.Context, object string) ([]string, error) {
+	b, err := hcp.getCustomMetadata(ctx, object, url.Values{"type": {"custom-metadata-info"}})
+	if err != nil {
+		return nil, err
+	}
+	var info customMetadataInfo
+	if err = xml.Unmarshal(b, &info); err != nil {
+		return nil, fmt.Errorf("error decoding custom-metadata-info: %w", err)
+	}
+	names := make([]string, 0, len(info.Annotations))
+	for _, a := range info.Annotations {
+		names = append(names, a.Name)
+	}
+	return names, nil
+}
+
+// GetAnnotation returns the custom-metadata annotation name of object.
+func (hcp *hcpBackend) GetAnnotation(ctx context.Context, object, name string) ([]byte, error) {
+	return hcp.getCustomMetadata(ctx, object, url.Values{
+		"type":       {"custom-metadata"},
+		"annotation": {name},
+	})
+}
+
+// annotationNames returns the names of the annotations HCP reports for
+// an object, as "name;size,..." in the X-HCP-CustomMetadataAnnotations
+// header of a GET on it or the customMetadataAnnotations of its listing.
+// ok is false if neither says whether the object has annotations.
+func annotationNames(entry Entry, h http.Header) (names []string, ok bool) {
+	list := h.Get("X-HCP-CustomMetadataAnnotations")
+	if list == "" {
+		list = entry.CustomMetadataAnnotations
+	}
+	if list == "" {
+		switch h.Get("X-HCP-CustomMetadata") {
+		case "true":
+			return nil, false
+		case "false":
+			return nil, true
+		}
+		return nil, entry.EntryType == "object" && !entry.CustomMetadata
+	}
+	for _, a := range strings.Split(list, ",") {
+		if name := strings.TrimSpace(strings.SplitN(a, ";", 2)[0]); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, true
+}
+
+// fetchAnnotations returns all custom-metadata annotations of the object
+// of entry, GET on which returned the headers h.
+func fetchAnnotations(ctx context.Context, entry Entry, h http.Header) ([]annotation, error) {
+	if annotationMode == annotationsSkip {
+		return nil, nil
+	}
+	names, ok := annotationNames(entry, h)
+	if !ok {
+		var err error
+		if names, err = hcp.ListAnnotations(ctx, entry.ObjectPath); err != nil {
+			return nil, fmt.Errorf("unable to list annotations: %w", err)
+		}
+	}
+	annotations := make([]annotation, 0, len(names))
+	for _, name := range names {
+		data, err := hcp.GetAnnotation(ctx, entry.ObjectPath, name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get annotation %s: %w", name, err)
+		}
+		annotations = append(annotations, annotation{name: name, data: data})
+	}
+	return annotations, nil
+}
+
+// embedAnnotations moves the annotations to be stored as user metadata
+// of the object into meta and returns the rest, to be stored as sidecar
+// objects.
+func embedAnnotations(annotations []annotation, meta map[string]string) []annotation {
+	if annotationMode != annotationsMetadata {
+		return annotations
+	}
+	var sidecars []annotation
+	size := 0
+	for _, a := range annotations {
+		v := base64.StdEncoding.EncodeToString(a.data)
+		if size+len(v) > maxAnnotationMetadata {
+			sidecars = append(sidecars, a)
+			continue
+		}
+		size += len(v)
+		meta["hcp-annotation-"+a.name] = v
+	}
+	return sidecars
+}
+
+// annotationObjectName returns the name of the sidecar object of
+// annotation name of the object key.
+func annotationObjectName(key, name string) string {
+	return annotationPrefix + key + annotationSuffix + name + ".xml"
+}
+
+// uploadAnnotations stores the annotations of the object oi as sidecar
+// objects, under the retention and legal hold of the object. Sidecars
+// already on MinIO are left as they are.
+func uploadAnnotations(ctx context.Context, oi miniogo.ObjectInfo, annotations []annotation) error {
+	if len(annotations) == 0 {
+		return nil
+	}
+	opts := miniogo.PutObjectOptions{
+		ContentType: "application/xml",
+		Internal: miniogo.AdvancedPutOptions{
+			SourceMTime: oi.LastModified,
+		},
+	}
+	ret, err := headerRetention(oi.Metadata)
+	if err != nil {
+		return err
+	}
+	if err = ret.apply(&opts); err != nil {
+		return err
+	}
+	for _, a := range annotations {
+		name := annotationObjectName(oi.Key, a.name)
+		if _, err := minioClient.StatObject(ctx, minioBucket, name, miniogo.StatObjectOptions{}); err == nil {
+			logDMsg("annotation already exists on MinIO "+name+" not migrated", nil)
+			continue
+		}
+		_, err := minioClient.PutObject(ctx, minioBucket, name, bytes.NewReader(a.data), int64(len(a.data)), opts)
+		if err != nil {
+			return fmt.Errorf("unable to upload annotation %s: %w", a.name, err)
+		}
+		logDMsg("Uploaded annotation "+name+" successfully", nil)
+	}
+	return nil
+}
+
+// uploadMissingAnnotations uploads the sidecar annotations of the object
+// of entry, found on MinIO as oi, that a previous run did not get to.
+// Sidecars are uploaded after their object.
+func uploadMissingAnnotations(ctx context.Context, oi miniogo.ObjectInfo, entry Entry) error {
+	annotations, err := fetchAnnotations(ctx, entry, oi.Metadata)
+	if err != nil {
+		return err
+	}
+	// the embedded annotations are on the object already
+	return uploadAnnotations(ctx, oi, embedAnnotations(annotations, make(map[string]string)))
+}
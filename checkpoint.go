@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const listCheckpointFile = "list_checkpoint"
+
+// listCheckpoint is an append-only journal kept in --data-dir for each
+// listing run, so that an interrupted listing can be finished with
+// `list --resume`. Each line of the journal is one of
+//
+//	listing <object listing file name>
+//	format <listing format>
+//	pending <directory path>
+//	done <directory path>
+//	complete
+//
+// A directory is journaled as done only after all the objects found in
+// it have been flushed to the object listing.
+type listCheckpoint struct {
+	f        *os.File
+	w        *bufio.Writer
+	listFile string
+	format   string
+	complete bool
+
+	// pending holds directories that are not yet done, known holds all
+	// directories journaled by a previous run.
+	pending map[string]struct{}
+	known   map[string]struct{}
+	// doneBatch holds directories to journal as done on the next commit.
+	doneBatch []string
+}
+
+func listCheckpointPath(prefix string) string {
+	if prefix == "" {
+		return path.Join(dirPath, listCheckpointFile+".log")
+	}
+	return path.Join(dirPath, fmt.Sprintf("%s_%s.log", listCheckpointFile, fileNamePrefix(prefix)))
+}
+
+// newListCheckpoint starts a new journal for prefix, replacing the
+// journal of any previous run.
+func newListCheckpoint(prefix, listFile, format string) (*listCheckpoint, error) {
+	f, err := os.OpenFile(listCheckpointPath(prefix), os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_SYNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	cp := &listCheckpoint{
+		f:        f,
+		w:        bufio.NewWriter(f),
+		listFile: listFile,
+		format:   format,
+		pending:  make(map[string]struct{}),
+	}
+	fmt.Fprintf(cp.w, "listing %s\nformat %s\n", listFile, format)
+	if err = cp.w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return cp, nil
+}
+
+// loadListCheckpoint reads the journal of the previous run for prefix
+// and opens it for appending. An error satisfying os.IsNotExist is
+// returned if there is no journal to resume from.
+func loadListCheckpoint(prefix string) (*listCheckpoint, error) {
+	f, err := os.OpenFile(listCheckpointPath(prefix), os.O_RDWR|os.O_APPEND|os.O_SYNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	cp := &listCheckpoint{
+		f:       f,
+		format:  listFormatText,
+		pending: make(map[string]struct{}),
+		known:   make(map[string]struct{}),
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		record := strings.SplitN(scanner.Text(), " ", 2)
+		arg := ""
+		if len(record) == 2 {
+			arg = record[1]
+		}
+		switch record[0] {
+		case "listing":
+			cp.listFile = arg
+		case "format":
+			cp.format = arg
+		case "pending":
+			cp.known[arg] = struct{}{}
+			cp.pending[arg] = struct{}{}
+		case "done":
+			cp.known[arg] = struct{}{}
+			delete(cp.pending, arg)
+		case "complete":
+			cp.complete = true
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if cp.listFile == "" {
+		f.Close()
+		return nil, fmt.Errorf("list checkpoint %s does not name an object listing", listCheckpointPath(prefix))
+	}
+	cp.w = bufio.NewWriter(f)
+	return cp, nil
+}
+
+// addPending journals dir as found but not yet listed.
+func (cp *listCheckpoint) addPending(dir string) error {
+	cp.pending[dir] = struct{}{}
+	_, err := cp.w.WriteString("pending " + dir + "\n")
+	return err
+}
+
+// markDone records dir as listed. It is journaled by the next commit.
+func (cp *listCheckpoint) markDone(dir string) {
+	cp.doneBatch = append(cp.doneBatch, dir)
+}
+
+// commit flushes the object listing in data and then journals the
+// directories marked as done since the last commit.
+func (cp *listCheckpoint) commit(data *bufio.Writer) error {
+	if err := data.Flush(); err != nil {
+		return err
+	}
+	for _, dir := range cp.doneBatch {
+		delete(cp.pending, dir)
+		if _, err := cp.w.WriteString("done " + dir + "\n"); err != nil {
+			return err
+		}
+	}
+	cp.doneBatch = cp.doneBatch[:0]
+	return cp.w.Flush()
+}
+
+// markComplete journals that no directories are left to list.
+func (cp *listCheckpoint) markComplete() error {
+	cp.complete = true
+	if _, err := cp.w.WriteString("complete\n"); err != nil {
+		return err
+	}
+	return cp.w.Flush()
+}
+
+func (cp *listCheckpoint) Close() error {
+	return cp.f.Close()
+}
+
+// loadListedObjects returns the objects already recorded in the object
+// listing at listPath under one of the pending directories, so that they
+// are not written again when those directories are listed once more. A
+// partially written last line is truncated from the listing. All objects
+// in the listing are added to stats.
+func loadListedObjects(listPath string, pending map[string]struct{}, stats *listingStats) (map[string]struct{}, error) {
+	f, err := os.OpenFile(listPath, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	listed := make(map[string]struct{})
+	var offset int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(len(line))
+		entry, err := parseListingLine(strings.TrimSuffix(line, "\n"))
+		if err != nil {
+			continue
+		}
+		stats.addObject(entry)
+		if _, ok := pending[path.Dir(entry.ObjectPath)]; ok {
+			listed[entry.ObjectPath] = struct{}{}
+		}
+	}
+	return listed, f.Truncate(offset)
+}
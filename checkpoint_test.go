@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestLoadListedObjects(t *testing.T) {
+	testCases := []struct {
+		listing   string
+		pending   []string
+		listed    []string
+		truncated string
+		objects   int64
+	}{
+		{"", []string{"/rest/a"}, nil, "", 0},
+		{"/rest/a/1\n/rest/b/2\n", []string{"/rest/a"}, []string{"/rest/a/1"}, "/rest/a/1\n/rest/b/2\n", 2},
+		{"/rest/a/1\n/rest/a/2\n", nil, nil, "/rest/a/1\n/rest/a/2\n", 2},
+		// a partially written last line is truncated
+		{"/rest/a/1\n/rest/a/2", []string{"/rest/a"}, []string{"/rest/a/1"}, "/rest/a/1\n", 1},
+		{"/rest/a/1\n{\"objectPath\":\"/rest/a/2\",\"ty", []string{"/rest/a"}, []string{"/rest/a/1"}, "/rest/a/1\n", 1},
+		{"/rest/a", []string{"/rest"}, nil, "", 0},
+		// jsonl entries
+		{"{\"objectPath\":\"/rest/a/1\",\"type\":\"object\",\"size\":3}\n", []string{"/rest/a"}, []string{"/rest/a/1"},
+			"{\"objectPath\":\"/rest/a/1\",\"type\":\"object\",\"size\":3}\n", 1},
+	}
+	for i, testCase := range testCases {
+		listPath := path.Join(t.TempDir(), "listing")
+		if err := ioutil.WriteFile(listPath, []byte(testCase.listing), 0600); err != nil {
+			t.Fatal(err)
+		}
+		pending := make(map[string]struct{})
+		for _, dir := range testCase.pending {
+			pending[dir] = struct{}{}
+		}
+		stats := newListingStats("/rest")
+		listed, err := loadListedObjects(listPath, pending, stats)
+		if err != nil {
+			t.Errorf("Test %d: unexpected error %v", i+1, err)
+			continue
+		}
+		want := make(map[string]struct{})
+		for _, object := range testCase.listed {
+			want[object] = struct{}{}
+		}
+		if !reflect.DeepEqual(listed, want) {
+			t.Errorf("Test %d: listed %v, want %v", i+1, listed, want)
+		}
+		b, err := ioutil.ReadFile(listPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != testCase.truncated {
+			t.Errorf("Test %d: listing %q after loading, want %q", i+1, b, testCase.truncated)
+		}
+		if stats.Objects != testCase.objects {
+			t.Errorf("Test %d: %d objects added to stats, want %d", i+1, stats.Objects, testCase.objects)
+		}
+	}
+}
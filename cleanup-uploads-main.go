@@ -0,0 +1,170 @@
+/*
+ * MinIO Client (C) 2021 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/minio/cli"
+	miniogo "github.com/minio/minio-go/v7"
+	"github.com/minio/minio/pkg/console"
+)
+
+var cleanupUploadsFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "data-dir, d",
+		Usage: "work directory of a migration, whose record of multipart uploads to resume drops the uploads aborted",
+	},
+	cli.StringFlag{
+		Name:  "prefix",
+		Usage: "only abort uploads of objects under this prefix",
+	},
+	cli.DurationFlag{
+		Name:  "older-than",
+		Usage: "only abort uploads initiated at least this long ago",
+		Value: 24 * time.Hour,
+	},
+	cli.BoolFlag{
+		Name:  "fake",
+		Usage: "only print the uploads that would be aborted",
+	},
+	cli.BoolFlag{
+		Name:  "insecure, i",
+		Usage: "disable TLS certificate verification",
+	},
+	cli.BoolFlag{
+		Name:  "log, l",
+		Usage: "enable logging",
+	},
+	cli.BoolFlag{
+		Name:  "debug",
+		Usage: "enable debugging",
+	},
+}
+
+var cleanupUploadsCmd = cli.Command{
+	Name:   "cleanup-uploads",
+	Usage:  "Abort stale incomplete multipart uploads left in the MinIO bucket by migrations",
+	Action: cleanupUploadsAction,
+	Flags:  cleanupUploadsFlags,
+	CustomHelpTemplate: `NAME:
+  {{.HelpName}} - {{.Usage}}
+
+USAGE:
+  {{.HelpName}} [--data-dir, --prefix, --older-than, --fake]
+
+FLAGS:
+  {{range .VisibleFlags}}{{.}}
+  {{end}}
+
+migrate resumes the multipart uploads of large objects recorded in its --data-dir, uploads left by
+runs that are not resumed stay in MINIO_BUCKET until they are aborted.
+
+EXAMPLES:
+1. Print the uploads in bucket miniobucket initiated more than a week ago
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio cleanup-uploads --older-than 168h --fake
+
+2. Abort the uploads in bucket miniobucket initiated more than a day ago, and forget those recorded in /tmp/data
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio cleanup-uploads --data-dir /tmp/data
+`,
+}
+
+func cleanupUploadsAction(cliCtx *cli.Context) error {
+	ctx := context.Background()
+	logFlag = cliCtx.Bool("log")
+	debugFlag = cliCtx.Bool("debug")
+	dryRun = cliCtx.Bool("fake")
+	dirPath = cliCtx.String("data-dir")
+	if err := initMinioClient(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	if minioBucket == "" {
+		console.Fatalln(fmt.Errorf("MINIO_BUCKET needs to be set"))
+	}
+	var records []largeUploadRecord
+	if dirPath != "" {
+		var err error
+		if records, err = readLargeUploads(dirPath); err != nil {
+			console.Fatalln(err)
+		}
+	}
+
+	before := time.Now().Add(-cliCtx.Duration("older-than"))
+	core := miniogo.Core{Client: minioClient}
+	aborted := make(map[string]struct{})
+	var count, failed int
+	var listErr error
+	for upload := range minioClient.ListIncompleteUploads(ctx, minioBucket, cliCtx.String("prefix"), true) {
+		if upload.Err != nil {
+			listErr = upload.Err
+			break
+		}
+		if upload.Initiated.After(before) {
+			continue
+		}
+		count++
+		age := humanize.RelTime(upload.Initiated, time.Now(), "ago", "from now")
+		if dryRun {
+			fmt.Printf("%s %s initiated %s\n", upload.Key, upload.UploadID, age)
+			continue
+		}
+		if err := core.AbortMultipartUpload(ctx, minioBucket, upload.Key, upload.UploadID); err != nil {
+			console.Errorln(fmt.Errorf("unable to abort upload %s of %s: %w", upload.UploadID, upload.Key, err))
+			failed++
+			continue
+		}
+		aborted[upload.UploadID] = struct{}{}
+		logMsg(fmt.Sprintf("Aborted upload %s of %s initiated %s", upload.UploadID, upload.Key, age))
+	}
+
+	// the uploads aborted before a listing error are forgotten too, a
+	// migration would fail to resume them
+	if len(aborted) > 0 && dirPath != "" {
+		kept := records[:0]
+		for _, rec := range records {
+			if _, ok := aborted[rec.UploadID]; !ok || rec.Bucket != minioBucket {
+				kept = append(kept, rec)
+			}
+		}
+		if err := writeLargeUploads(dirPath, kept); err != nil {
+			return err
+		}
+	}
+	if listErr != nil {
+		return listErr
+	}
+	if dryRun {
+		logMsg(fmt.Sprintf("%d uploads to abort", count))
+		return nil
+	}
+	if failed > 0 {
+		return fmt.Errorf("unable to abort %d of %d uploads", failed, count)
+	}
+	logMsg(fmt.Sprintf("Aborted %d uploads", count))
+	return nil
+}
@@ -0,0 +1,154 @@
+/*
+ * MinIO Client (C) 2021 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/minio/cli"
+	"github.com/minio/minio/pkg/console"
+)
+
+var discoverFlags = []cli.Flag{
+	cli.IntFlag{
+		Name:  "depth",
+		Usage: "depth of the directories to use as prefixes, 1 for the top level directories",
+		Value: 1,
+	},
+	cli.IntFlag{
+		Name:  "hosts",
+		Usage: "number of prefix files to write, one for each host running list",
+		Value: 1,
+	},
+	cli.StringFlag{
+		Name:  "balance-by",
+		Usage: "balance prefix files by estimated object 'count' or 'bytes'",
+		Value: balanceByCount,
+	},
+	cli.IntFlag{
+		Name:  "list-workers",
+		Usage: "number of directories to list in parallel",
+		Value: 8,
+	},
+	cli.IntFlag{
+		Name:  "list-retries",
+		Usage: "number of times to retry listing a directory",
+		Value: 5,
+	},
+}
+
+const prefixesFile = "discovered_prefixes.txt"
+
+var discoverCmd = cli.Command{
+	Name:   "discover-prefixes",
+	Usage:  "Discover balanced sets of prefixes in HCP namespace for list --prefixes-file",
+	Action: discoverAction,
+	Flags:  append(allFlags, discoverFlags...),
+	CustomHelpTemplate: `NAME:
+  {{.HelpName}} - {{.Usage}}
+
+USAGE:
+  {{.HelpName}} --auth-token --namespace-url --host-header --data-dir [--depth, --hosts, --balance-by]
+
+FLAGS:
+  {{range .VisibleFlags}}{{.}}
+  {{end}}
+
+Objects found above --depth are not under any of the prefixes, they are written to an object
+listing in --data-dir that can be migrated directly.
+
+EXAMPLES:
+1. Write 4 prefix files balanced by estimated bytes, from the directories two levels below the namespace URL
+   $ hcp-to-minio discover-prefixes -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" --depth 2 --hosts 4 --balance-by bytes
+
+2. List the prefixes of the first prefix file on the first host
+   $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--prefixes-file /tmp/data/discovered_prefixes.txt_1.10-01-2021-09-30-00
+`,
+}
+
+func discoverAction(cliCtx *cli.Context) error {
+	checkArgsAndInit(cliCtx)
+	ctx := context.Background()
+	depth := cliCtx.Int("depth")
+	hosts := cliCtx.Int("hosts")
+	by := cliCtx.String("balance-by")
+	listWorkers = cliCtx.Int("list-workers")
+	listRetries = cliCtx.Int("list-retries")
+	listFormat = listFormatText
+	if depth <= 0 || hosts <= 0 || listWorkers <= 0 {
+		console.Fatalln(fmt.Errorf("--depth, --hosts and --list-workers must be greater than 0"))
+	}
+	if by != balanceByCount && by != balanceByBytes {
+		console.Fatalln(fmt.Errorf("--balance-by must be one of %s or %s", balanceByCount, balanceByBytes))
+	}
+
+	u, err := url.Parse(namespaceURL)
+	if err != nil {
+		return err
+	}
+	lw, err := newListingWriter(path.Clean(u.Path), "discovered")
+	if err != nil {
+		return err
+	}
+	defer lw.Close()
+	prefixes, err := hcp.discoverPrefixes(ctx, depth, by, lw)
+	if err != nil {
+		return err
+	}
+	logMsg(fmt.Sprintf("Objects above depth %d written to %s", depth, lw.name))
+
+	for i, shard := range balancePrefixes(prefixes, hosts) {
+		name := getFileName(prefixesFile, fmt.Sprint(i+1))
+		if err := writeLines(path.Join(dirPath, name), shard.prefixes); err != nil {
+			return err
+		}
+		estimate := humanize.Comma(shard.estimate) + " objects"
+		if by == balanceByBytes {
+			estimate = humanize.IBytes(uint64(shard.estimate))
+		}
+		console.Infoln(fmt.Sprintf("%s: %d prefixes, about %s", name, len(shard.prefixes), estimate))
+	}
+	return nil
+}
+
+func writeLines(name string, lines []string) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		if _, err = w.WriteString(line + "\n"); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
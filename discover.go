@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	balanceByCount = "count"
+	balanceByBytes = "bytes"
+)
+
+// discoveredDir is a directory listed while discovering prefixes.
+type discoveredDir struct {
+	path    string
+	objects int64 // objects directly in the directory
+	bytes   int64 // bytes of the objects directly in the directory
+	subDirs []string
+	entries []Entry // objects directly in the directory, above the discovery depth only
+	err     error
+}
+
+// weight returns the count or bytes of the objects directly in d.
+func (d discoveredDir) weight(by string) int64 {
+	if by == balanceByBytes {
+		return d.bytes
+	}
+	return d.objects
+}
+
+// discoveredPrefix is a prefix to list with the estimated count or bytes
+// of the objects under it.
+type discoveredPrefix struct {
+	prefix   string
+	estimate int64
+}
+
+// prefixShard is the set of prefixes to be listed by one host.
+type prefixShard struct {
+	prefixes []string
+	estimate int64
+}
+
+// discoverPrefixes walks the namespace down to depth and returns the
+// directories found at that depth as prefixes for `list`, with the size
+// of each estimated from the directories seen on the way. Objects above
+// that depth are not under any prefix and are written to lw instead.
+// Directories that could not be listed are returned as prefixes too, so
+// that no part of the namespace is left out.
+func (hcp *hcpBackend) discoverPrefixes(ctx context.Context, depth int, by string, lw *listingWriter) ([]discoveredPrefix, error) {
+	u, err := url.Parse(namespaceURL)
+	if err != nil {
+		return nil, err
+	}
+	root := path.Clean(u.Path)
+	var (
+		level    = []string{root}
+		frontier []discoveredDir
+		listed   int64
+		weight   int64
+	)
+	for d := 0; d <= depth && len(level) > 0; d++ {
+		dirs := hcp.discoverLevel(ctx, level, d < depth)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		level = nil
+		for _, dir := range dirs {
+			if dir.err != nil {
+				if d == 0 {
+					return nil, dir.err
+				}
+				logMsg(fmt.Sprintf("Couldn't list directory %s, using it as a prefix: %s", dir.path, dir.err))
+				frontier = append(frontier, dir)
+				continue
+			}
+			listed++
+			weight += dir.weight(by)
+			for _, entry := range dir.entries {
+				if err := lw.writeEntry(entry); err != nil {
+					return nil, err
+				}
+			}
+			if d == depth {
+				frontier = append(frontier, dir)
+				continue
+			}
+			level = append(level, dir.subDirs...)
+		}
+		logMsg(fmt.Sprintf("Listed %d directories at depth %d", len(dirs), d))
+	}
+
+	// Directories above the frontier were listed in full and their
+	// objects written to lw. Estimate what lies below each frontier
+	// directory from the average directory seen during the walk.
+	var avg int64
+	if listed > 0 {
+		avg = weight / listed
+	}
+	prefixes := make([]discoveredPrefix, 0, len(frontier))
+	for _, dir := range frontier {
+		estimate := avg
+		if dir.err == nil {
+			estimate = dir.weight(by) + int64(len(dir.subDirs))*avg
+		}
+		prefixes = append(prefixes, discoveredPrefix{
+			prefix:   strings.TrimPrefix(dir.path, root+"/"),
+			estimate: estimate,
+		})
+	}
+	return prefixes, lw.Flush()
+}
+
+// discoverLevel lists the directories of one level of the walk in
+// parallel, keeping the objects found if keepObjects is set.
+func (hcp *hcpBackend) discoverLevel(ctx context.Context, dirs []string, keepObjects bool) []discoveredDir {
+	results := make([]discoveredDir, len(dirs))
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < listWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = hcp.discoverDir(ctx, dirs[i], keepObjects)
+			}
+		}()
+	}
+	for i := range dirs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func (hcp *hcpBackend) discoverDir(ctx context.Context, root string, keepObjects bool) discoveredDir {
+	d := discoveredDir{
+		path: root,
+	}
+	var dir *Directory
+	d.err = withRetry(ctx, listRetries, "listing of "+root, func() (err error) {
+		dir, err = hcp.readDirectory(ctx, root)
+		return err
+	})
+	if d.err != nil {
+		return d
+	}
+	for _, entry := range dir.Entries {
+		entry.ObjectPath = path.Join(dir.Path, entry.URLName)
+		switch entry.EntryType {
+		case "object":
+			d.objects++
+			d.bytes += entry.Size
+			if keepObjects {
+				d.entries = append(d.entries, entry)
+			}
+		case "directory":
+			d.subDirs = append(d.subDirs, entry.ObjectPath)
+		}
+	}
+	return d
+}
+
+// balancePrefixes spreads prefixes over n shards so that the estimated
+// count or bytes of each shard is about the same, placing the largest
+// prefixes first on the least loaded shard.
+func balancePrefixes(prefixes []discoveredPrefix, n int) []prefixShard {
+	sort.Slice(prefixes, func(i, j int) bool {
+		return prefixes[i].estimate > prefixes[j].estimate
+	})
+	shards := make([]prefixShard, n)
+	for _, p := range prefixes {
+		min := 0
+		for i := range shards {
+			if shards[i].estimate < shards[min].estimate {
+				min = i
+			}
+		}
+		shards[min].prefixes = append(shards[min].prefixes, p.prefix)
+		shards[min].estimate += p.estimate
+	}
+	for i := range shards {
+		sort.Strings(shards[i].prefixes)
+	}
+	return shards
+}
@@ -3,13 +3,19 @@ package main
 import (
 	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"time"
 )
 
-const amzMetaPrefix = "x-amz-meta-"
+const (
+	amzMetaPrefix = "x-amz-meta-"
+
+	// documentDateFormat is the format of the dates in a Document.
+	documentDateFormat = "2006-01-02T15:04:05"
+)
 
 // Document represents structure of annotation document in HCP
 type Document struct {
@@ -45,7 +51,7 @@ func (dDate *DocumentDate) UnmarshalXML(d *xml.Decoder, startElement xml.StartEl
 	if err != nil {
 		return err
 	}
-	docDate, err := time.Parse("2006-01-02T15:04:05", dateStr)
+	docDate, err := time.Parse(documentDateFormat, dateStr)
 	if err != nil {
 		return errInvalidDocumentDate
 	}
@@ -59,19 +65,40 @@ func (dDate DocumentDate) MarshalXML(e *xml.Encoder, startElement xml.StartEleme
 	if dDate.Time.IsZero() {
 		return nil
 	}
-	return e.EncodeElement(dDate.Format("yyyy-MM-dd'T'HH:mm:ss"), startElement)
+	return e.EncodeElement(dDate.Format(documentDateFormat), startElement)
+}
+
+// String returns the date in the format of the Document, or an empty
+// string if it is not set.
+func (dDate DocumentDate) String() string {
+	if dDate.Time.IsZero() {
+		return ""
+	}
+	return dDate.Format(documentDateFormat)
 }
 
 func getDocumentAnnotation(fileName string) (d *Document, err error) {
-	var doc Document
 	f, err := os.Open(fileName)
 	if err != nil {
 		return d, err
 	}
 	defer f.Close()
-	documentXML, _ := ioutil.ReadAll(f)
+	return parseDocument(f)
+}
+
+// parseDocument parses a Document annotation from r. The Document must
+// name the account and report file the MinIO object name is derived from.
+func parseDocument(r io.Reader) (*Document, error) {
+	documentXML, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var doc Document
 	if err = xml.Unmarshal(documentXML, &doc); err != nil {
-		return d, err
+		return nil, err
+	}
+	if doc.EncryptedAcctNum == "" || doc.ReportFileName == "" {
+		return nil, fmt.Errorf("document has no encryptedaccountnumber or reportfilename")
 	}
 	return &doc, nil
 }
@@ -83,15 +110,23 @@ func (d *Document) getMinIOObjectName() string {
 	return path.Join(d.EncryptedAcctNum, d.ReportType, d.Type, d.ReportRunDate.Format("2006/01/02"), d.FileFormat, d.ReportFileName)
 }
 
+// getObjectMetadata returns the user metadata derived from the Document
+// annotation, leaving out fields that are not set.
 func (d *Document) getObjectMetadata() map[string]string {
 	m := make(map[string]string)
-	m[amzMetaPrefix+"Report-Start-Date"] = d.ReportPeriodStartDate.Format("yyyy-MM-dd'T'HH:mm:ss")
-	m[amzMetaPrefix+"Report-End-Date"] = d.ReportPeriodEndDate.Format("yyyy-MM-dd'T'HH:mm:ss")
-	m[amzMetaPrefix+"Report-Run-Date"] = d.ReportRunDate.Format("yyyy-MM-dd'T'HH:mm:ss")
-	m[amzMetaPrefix+"DocType"] = d.Type
-	m[amzMetaPrefix+"Locale"] = d.Locale
-	m[amzMetaPrefix+"ReportFamily"] = d.ReportFamily
-	m[amzMetaPrefix+"ReportType"] = d.ReportType
-	m[amzMetaPrefix+"ReportFileName"] = d.ReportFileName
+	for k, v := range map[string]string{
+		"Report-Start-Date": d.ReportPeriodStartDate.String(),
+		"Report-End-Date":   d.ReportPeriodEndDate.String(),
+		"Report-Run-Date":   d.ReportRunDate.String(),
+		"DocType":           d.Type,
+		"Locale":            d.Locale,
+		"ReportFamily":      d.ReportFamily,
+		"ReportType":        d.ReportType,
+		"ReportFileName":    d.ReportFileName,
+	} {
+		if v != "" {
+			m[amzMetaPrefix+k] = v
+		}
+	}
 	return m
 }
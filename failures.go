@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// failsFileSep separates the object from its error in the lines of a
+// fails file.
+const failsFileSep = " : "
+
+// Classes of the errors of objects that failed to migrate.
+const (
+	failureTransient = "transient"
+	failureNotFound  = "not-found"
+	failureDenied    = "denied"
+	failureRetention = "retention"
+	failureVerify    = "verify"
+	failureOther     = "other"
+)
+
+var failureClassNames = []string{failureTransient, failureNotFound, failureDenied, failureRetention, failureVerify, failureOther}
+
+// failureMessages classifies errors by their message, in order. Only the
+// message is left of an error once written to a fails file.
+var failureMessages = []struct {
+	class string
+	parts []string
+}{
+	{failureVerify, []string{"reported by HCP", "bytes were verified", "not supported for verification"}},
+	{failureRetention, []string{"cannot be represented on MinIO", "unknown retention value", "invalid " + hcpRetentionHeader + " header"}},
+	{failureNotFound, []string{"NoSuchKey", "The specified key does not exist", "object not found"}},
+	{failureDenied, []string{"Access Denied", "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch"}},
+	{failureTransient, []string{
+		"connection reset", "connection refused", "broken pipe", "timeout", "unexpected EOF",
+		"no such host", "deadline exceeded", "SlowDown", "ServiceUnavailable", "InternalError", "RequestTimeout",
+	}},
+}
+
+// failureStatus matches the HTTP status in the message of HCP errors.
+var failureStatus = regexp.MustCompile(`Status:(\d{3})`)
+
+// classifyFailure returns the class of the error message of a failed
+// object, by the HTTP status of HCP errors or else by the message.
+func classifyFailure(msg string) string {
+	if m := failureStatus.FindStringSubmatch(msg); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		switch {
+		case code == 404:
+			return failureNotFound
+		case code == 401 || code == 403:
+			return failureDenied
+		case code == 408 || code == 429 || code >= 500:
+			return failureTransient
+		}
+	}
+	for _, fm := range failureMessages {
+		for _, p := range fm.parts {
+			if strings.Contains(msg, p) {
+				return fm.class
+			}
+		}
+	}
+	return failureOther
+}
+
+// failedObject is an object recorded in a fails file.
+type failedObject struct {
+	object string
+	msg    string
+	class  string
+}
+
+// readFailsFiles returns the objects recorded in the fails files, in the
+// order first found. An object in more than one file keeps the error of
+// the last one.
+func readFailsFiles(names []string) ([]failedObject, error) {
+	var failed []failedObject
+	index := make(map[string]int)
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				continue
+			}
+			kv := strings.SplitN(line, failsFileSep, 2)
+			if len(kv) != 2 || kv[0] == "" {
+				logMsg(fmt.Sprintf("skipping malformed line in %s: %s", name, line))
+				continue
+			}
+			fo := failedObject{object: kv[0], msg: kv[1], class: classifyFailure(kv[1])}
+			if i, ok := index[fo.object]; ok {
+				failed[i] = fo
+				continue
+			}
+			index[fo.object] = len(failed)
+			failed = append(failed, fo)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", name, err)
+		}
+	}
+	return failed, nil
+}
+
+// errNoFailsFile is returned by latestFailsFile if there is no fails file.
+var errNoFailsFile = errors.New("no " + failMigFile)
+
+// latestFailsFile returns the fails file of the latest migration in dir.
+func latestFailsFile(dir string) (string, error) {
+	names, err := filepath.Glob(path.Join(dir, failMigFile+".*"))
+	if err != nil {
+		return "", err
+	}
+	var latest string
+	var latestTime time.Time
+	for _, name := range names {
+		// named by getFileName after the time of the run
+		t, err := time.ParseInLocation("01-02-2006-15-04-05", strings.TrimPrefix(path.Base(name), failMigFile+"."), time.Local)
+		if err != nil {
+			continue
+		}
+		if latest == "" || t.After(latestTime) {
+			latest, latestTime = name, t
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("%w in %s", errNoFailsFile, dir)
+	}
+	return latest, nil
+}
+
+// listedEntries returns the lines of the listing in listFile of the
+// objects in paths, by object path, so that they are migrated again with
+// the versions and attributes listed for them.
+func listedEntries(listFile string, paths map[string]bool) (map[string]string, error) {
+	f, err := os.Open(listFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := parseListingLine(line)
+		if err != nil || !paths[entry.ObjectPath] {
+			continue
+		}
+		lines[entry.ObjectPath] = line
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", listFile, err)
+	}
+	return lines, nil
+}
+
+// parseFailureClasses returns the set of failure classes given to
+// --error-class, empty for all.
+func parseFailureClasses(classes []string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	for _, c := range classes {
+		var known bool
+		for _, name := range failureClassNames {
+			known = known || c == name
+		}
+		if !known {
+			return nil, fmt.Errorf("invalid --error-class %s, must be one of %s", c, strings.Join(failureClassNames, ", "))
+		}
+		set[c] = true
+	}
+	return set, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestClassifyFailure(t *testing.T) {
+	testCases := []struct {
+		msg   string
+		class string
+	}{
+		// HCP errors by status
+		{"object not found Status:404", failureNotFound},
+		{"bad request Status:403", failureDenied},
+		{"bad request Status:401", failureDenied},
+		{"HCP busy Status:503", failureTransient},
+		{"part 3: bad request Status:503", failureTransient},
+		{"Status:429", failureTransient},
+		{"Status:408", failureTransient},
+		{"bad request Status:400", failureOther},
+		// by message
+		{"content SHA-256 AB does not match the CD reported by HCP", failureVerify},
+		{"only 3 of 5 bytes were verified", failureVerify},
+		{"hash scheme CRC32 is not supported for verification", failureVerify},
+		{"retention 'Deletion Prohibited' cannot be represented on MinIO, object refused", failureRetention},
+		{"unknown retention value -3, object refused", failureRetention},
+		{"invalid X-HCP-Retention header abc", failureRetention},
+		{"The specified key does not exist.", failureNotFound},
+		{"Access Denied.", failureDenied},
+		{"read tcp 10.0.0.1:443: connection reset by peer", failureTransient},
+		{"unexpected EOF", failureTransient},
+		{"context deadline exceeded", failureTransient},
+		{"key template: invalid object name", failureOther},
+		{"", failureOther},
+	}
+	for i, testCase := range testCases {
+		if class := classifyFailure(testCase.msg); class != testCase.class {
+			t.Errorf("Test %d: classifyFailure(%q) = %s, want %s", i+1, testCase.msg, class, testCase.class)
+		}
+	}
+}
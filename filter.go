@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/minio/cli"
+	miniogo "github.com/minio/minio-go/v7"
+)
+
+var filterFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:  "include",
+		Usage: "only select objects matching a glob ('*', '?' and '**' across directories) or a regular expression prefixed with 're:', relative to the namespace, may be repeated",
+	},
+	cli.StringSliceFlag{
+		Name:  "exclude",
+		Usage: "leave out objects matching a glob or a regular expression prefixed with 're:', may be repeated",
+	},
+	cli.StringFlag{
+		Name:  "min-size",
+		Usage: "only select objects of at least this size, e.g. 64KiB",
+	},
+	cli.StringFlag{
+		Name:  "max-size",
+		Usage: "only select objects of at most this size, e.g. 5GiB",
+	},
+	cli.StringFlag{
+		Name:  "ingested-before",
+		Usage: "only select objects ingested before a timestamp (RFC3339 or YYYY-MM-DD)",
+	},
+	cli.StringFlag{
+		Name:  "ingested-after",
+		Usage: "only select objects ingested at or after a timestamp (RFC3339 or YYYY-MM-DD)",
+	},
+}
+
+// pathPattern is an --include or --exclude pattern.
+type pathPattern struct {
+	re *regexp.Regexp
+	// literal is the part of a glob before its first wildcard, nothing
+	// outside of it can match. Empty for regular expressions.
+	literal string
+	isRegex bool
+	// subtree matches the directory of a glob ending in "/**", all of
+	// which the glob matches.
+	subtree *regexp.Regexp
+}
+
+func newPathPattern(s string) (pathPattern, error) {
+	if strings.HasPrefix(s, "re:") {
+		re, err := regexp.Compile(s[len("re:"):])
+		return pathPattern{re: re, isRegex: true}, err
+	}
+	s = strings.TrimPrefix(s, "/")
+	p := pathPattern{literal: s}
+	if i := strings.IndexAny(s, "*?"); i >= 0 {
+		p.literal = s[:i]
+	}
+	var err error
+	if p.re, err = globToRegexp(s); err != nil {
+		return p, err
+	}
+	if strings.HasSuffix(s, "/**") {
+		p.subtree, err = globToRegexp(strings.TrimSuffix(s, "/**"))
+	}
+	return p, err
+}
+
+// globToRegexp converts a glob to a regular expression. '*' and '?' do
+// not match '/', '**' matches across directories.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					// "**/" matches no directory as well
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// mayMatchUnder reports whether the pattern can match anything in the
+// directory dir.
+func (p pathPattern) mayMatchUnder(dir string) bool {
+	if p.isRegex {
+		return true
+	}
+	dir += "/"
+	return strings.HasPrefix(dir, p.literal) || strings.HasPrefix(p.literal, dir)
+}
+
+// objectFilter selects the objects to list or migrate.
+type objectFilter struct {
+	include        []pathPattern
+	exclude        []pathPattern
+	minSize        int64
+	maxSize        int64 // no limit if 0
+	ingestedBefore time.Time
+	ingestedAfter  time.Time
+}
+
+var filter objectFilter
+
+// parseFilterFlags sets filter from the filter flags.
+func parseFilterFlags(ctx *cli.Context) (err error) {
+	for _, s := range ctx.StringSlice("include") {
+		p, err := newPathPattern(s)
+		if err != nil {
+			return fmt.Errorf("invalid --include %s: %w", s, err)
+		}
+		filter.include = append(filter.include, p)
+	}
+	for _, s := range ctx.StringSlice("exclude") {
+		p, err := newPathPattern(s)
+		if err != nil {
+			return fmt.Errorf("invalid --exclude %s: %w", s, err)
+		}
+		filter.exclude = append(filter.exclude, p)
+	}
+	if s := ctx.String("min-size"); s != "" {
+		sz, err := humanize.ParseBytes(s)
+		if err != nil {
+			return fmt.Errorf("invalid --min-size %s: %w", s, err)
+		}
+		filter.minSize = int64(sz)
+	}
+	if s := ctx.String("max-size"); s != "" {
+		sz, err := humanize.ParseBytes(s)
+		if err != nil {
+			return fmt.Errorf("invalid --max-size %s: %w", s, err)
+		}
+		filter.maxSize = int64(sz)
+	}
+	if s := ctx.String("ingested-before"); s != "" {
+		if filter.ingestedBefore, err = parseSince(s); err != nil {
+			return fmt.Errorf("invalid --ingested-before %s: %w", s, err)
+		}
+	}
+	if s := ctx.String("ingested-after"); s != "" {
+		if filter.ingestedAfter, err = parseSince(s); err != nil {
+			return fmt.Errorf("invalid --ingested-after %s: %w", s, err)
+		}
+	}
+	return nil
+}
+
+// hasAttributeFilters reports whether the filter depends on object
+// attributes rather than on the object path alone.
+func (f objectFilter) hasAttributeFilters() bool {
+	return f.minSize > 0 || f.maxSize > 0 || !f.ingestedBefore.IsZero() || !f.ingestedAfter.IsZero()
+}
+
+// matchPath reports whether the object at the HCP path object is selected
+// by --include and --exclude.
+func (f objectFilter) matchPath(object string) bool {
+	key := minioObjectName(object)
+	if len(f.include) > 0 {
+		var included bool
+		for _, p := range f.include {
+			if p.re.MatchString(key) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, p := range f.exclude {
+		if p.re.MatchString(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAttributes reports whether an object of size ingested at ingest
+// is selected by the size and ingest time filters.
+func (f objectFilter) matchAttributes(size int64, ingest time.Time) bool {
+	if size < f.minSize || (f.maxSize > 0 && size > f.maxSize) {
+		return false
+	}
+	if !f.ingestedBefore.IsZero() && !ingest.Before(f.ingestedBefore) {
+		return false
+	}
+	if !f.ingestedAfter.IsZero() && ingest.Before(f.ingestedAfter) {
+		return false
+	}
+	return true
+}
+
+// matchEntry reports whether the object in entry is selected. Entries
+// read from a text listing have no attributes, they are only matched by
+// path here and by matchObjectInfo once fetched from HCP.
+func (f objectFilter) matchEntry(entry Entry) bool {
+	if !f.matchPath(entry.ObjectPath) {
+		return false
+	}
+	if entry.EntryType == "" {
+		return true
+	}
+	return f.matchAttributes(entry.Size, time.Unix(entry.IngestTime, 0))
+}
+
+// matchObjectInfo reports whether an object fetched from HCP is selected
+// by the size and ingest time filters.
+func (f objectFilter) matchObjectInfo(oi miniogo.ObjectInfo) bool {
+	if !f.hasAttributeFilters() {
+		return true
+	}
+	ingest, err := strconv.ParseInt(oi.Metadata.Get("X-HCP-IngestTime"), 10, 64)
+	if err != nil {
+		return false
+	}
+	return f.matchAttributes(oi.Size, time.Unix(ingest, 0))
+}
+
+// pruneDir reports whether nothing in the HCP directory dir can be
+// selected, so that it need not be listed.
+func (f objectFilter) pruneDir(dir string) bool {
+	key := minioObjectName(dir)
+	if len(f.include) > 0 {
+		prune := true
+		for _, p := range f.include {
+			if p.mayMatchUnder(key) {
+				prune = false
+				break
+			}
+		}
+		if prune {
+			return true
+		}
+	}
+	for _, p := range f.exclude {
+		if p.subtree != nil && p.subtree.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
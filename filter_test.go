@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	testCases := []struct {
+		glob  string
+		name  string
+		match bool
+	}{
+		{"a/*.pdf", "a/b.pdf", true},
+		{"a/*.pdf", "a/b/c.pdf", false},
+		{"a/*.pdf", "a/b.pdfx", false},
+		{"a/?.pdf", "a/b.pdf", true},
+		{"a/?.pdf", "a/bc.pdf", false},
+		{"a/?", "a//", false},
+		{"a/**", "a/b/c.pdf", true},
+		{"a/**", "a", false},
+		{"a/**/c.pdf", "a/c.pdf", true},
+		{"a/**/c.pdf", "a/b/d/c.pdf", true},
+		{"a/**/c.pdf", "a/bc.pdf", false},
+		{"**.pdf", "a/b/c.pdf", true},
+		{"**/*.pdf", "c.pdf", true},
+		// regular expression characters are literal
+		{"a.b/(c)+[d]", "a.b/(c)+[d]", true},
+		{"a.b", "axb", false},
+		{"a$/^b", "a$/^b", true},
+	}
+	for i, testCase := range testCases {
+		re, err := globToRegexp(testCase.glob)
+		if err != nil {
+			t.Errorf("Test %d: unexpected error %v", i+1, err)
+			continue
+		}
+		if match := re.MatchString(testCase.name); match != testCase.match {
+			t.Errorf("Test %d: glob %q matches %q: %t, want %t", i+1, testCase.glob, testCase.name, match, testCase.match)
+		}
+	}
+}
+
+func TestMatchEntry(t *testing.T) {
+	june := time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC)
+	patterns := func(ss ...string) []pathPattern {
+		var ps []pathPattern
+		for _, s := range ss {
+			p, err := newPathPattern(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ps = append(ps, p)
+		}
+		return ps
+	}
+	object := func(path string, size int64, ingest time.Time) Entry {
+		return Entry{ObjectPath: path, EntryType: "object", Size: size, IngestTime: ingest.Unix()}
+	}
+	testCases := []struct {
+		filter objectFilter
+		entry  Entry
+		match  bool
+	}{
+		{objectFilter{}, object("/rest/a/b.pdf", 1, june), true},
+		// paths
+		{objectFilter{include: patterns("a/**")}, object("/rest/a/b.pdf", 1, june), true},
+		{objectFilter{include: patterns("/a/**")}, object("/rest/a/b.pdf", 1, june), true},
+		{objectFilter{include: patterns("c/**")}, object("/rest/a/b.pdf", 1, june), false},
+		{objectFilter{include: patterns("c/**", "**.pdf")}, object("/rest/a/b.pdf", 1, june), true},
+		{objectFilter{exclude: patterns("**.pdf")}, object("/rest/a/b.pdf", 1, june), false},
+		{objectFilter{include: patterns("a/**"), exclude: patterns("a/b.*")}, object("/rest/a/b.pdf", 1, june), false},
+		{objectFilter{include: patterns(`re:^a/b\.(pdf|txt)$`)}, object("/rest/a/b.txt", 1, june), true},
+		{objectFilter{include: patterns(`re:^a/b\.(pdf|txt)$`)}, object("/rest/a/b.doc", 1, june), false},
+		// attributes
+		{objectFilter{minSize: 10}, object("/rest/a", 10, june), true},
+		{objectFilter{minSize: 10}, object("/rest/a", 9, june), false},
+		{objectFilter{maxSize: 10}, object("/rest/a", 10, june), true},
+		{objectFilter{maxSize: 10}, object("/rest/a", 11, june), false},
+		{objectFilter{ingestedBefore: june}, object("/rest/a", 1, june), false},
+		{objectFilter{ingestedBefore: june}, object("/rest/a", 1, june.Add(-time.Second)), true},
+		{objectFilter{ingestedAfter: june}, object("/rest/a", 1, june), true},
+		{objectFilter{ingestedAfter: june}, object("/rest/a", 1, june.Add(-time.Second)), false},
+		// entries of text listings are only matched by path
+		{objectFilter{minSize: 10, ingestedAfter: june}, Entry{ObjectPath: "/rest/a"}, true},
+		{objectFilter{minSize: 10, exclude: patterns("a")}, Entry{ObjectPath: "/rest/a"}, false},
+	}
+	for i, testCase := range testCases {
+		if match := testCase.filter.matchEntry(testCase.entry); match != testCase.match {
+			t.Errorf("Test %d: matchEntry(%+v) = %t, want %t", i+1, testCase.entry, match, testCase.match)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -30,7 +31,30 @@ func closeResponse(resp *http.Response) {
 	}
 }
 
-func (hcp *hcpBackend) GetObject(object string) (r io.ReadCloser, oi miniogo.ObjectInfo, err error) {
+// minioObjectName returns the MinIO object name for an HCP object path.
+func minioObjectName(object string) string {
+	return strings.TrimPrefix(object, "/rest/") // default MinIO object name to same as HCP
+}
+
+// GetObject fetches object from HCP, or the given version of it if
+// versionID is not empty.
+func (hcp *hcpBackend) GetObject(object, versionID string) (r io.ReadCloser, oi miniogo.ObjectInfo, err error) {
+	return hcp.requestObject(http.MethodGet, object, versionID)
+}
+
+// HeadObject describes object on HCP, or the given version of it if
+// versionID is not empty, as GetObject does without fetching it.
+func (hcp *hcpBackend) HeadObject(object, versionID string) (miniogo.ObjectInfo, error) {
+	r, oi, err := hcp.requestObject(http.MethodHead, object, versionID)
+	if err != nil {
+		return oi, err
+	}
+	return oi, r.Close()
+}
+
+// requestObject sends a GET or HEAD request for object, or the given
+// version of it, to HCP.
+func (hcp *hcpBackend) requestObject(method, object, versionID string) (r io.ReadCloser, oi miniogo.ObjectInfo, err error) {
 	u, err := url.Parse(namespaceURL)
 	if err != nil {
 		return r, oi, err
@@ -39,7 +63,10 @@ func (hcp *hcpBackend) GetObject(object string) (r io.ReadCloser, oi miniogo.Obj
 	reqURL := u.String() // prints http://foo/bar.html
 
 	data := url.Values{}
-	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if versionID != "" {
+		data.Set("version", versionID)
+	}
+	req, err := http.NewRequest(method, reqURL, nil)
 	if err != nil {
 		logDMsg(fmt.Sprintf("Couldn't create a request with namespaceURL %s", reqURL), err)
 		return r, oi, err
@@ -91,7 +118,7 @@ func (hcp *hcpBackend) GetObject(object string) (r io.ReadCloser, oi miniogo.Obj
 
 	if resp.StatusCode != http.StatusOK {
 		closeResponse(resp)
-		return r, oi, fmt.Errorf("bad request Status:%d", resp.StatusCode)
+		return r, oi, newHCPError(resp)
 	}
 
 	var (
@@ -102,7 +129,7 @@ func (hcp *hcpBackend) GetObject(object string) (r io.ReadCloser, oi miniogo.Obj
 		closeResponse(resp)
 		return r, oi, fmt.Errorf("invalid X-HCP-Size header %w", err)
 	}
-	minioObjName := strings.TrimPrefix(object, "/rest/") // default MinIO object name to same as HCP
+	minioObjName := minioObjectName(object)
 	date, err := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
 	if err != nil {
 		closeResponse(resp)
@@ -113,5 +140,52 @@ func (hcp *hcpBackend) GetObject(object string) (r io.ReadCloser, oi miniogo.Obj
 		Key:          minioObjName,
 		Size:         int64(objSz),
 		LastModified: date,
+		VersionID:    resp.Header.Get("X-HCP-VersionId"),
+		ContentType:  resp.Header.Get("Content-Type"),
+		Metadata:     resp.Header,
 	}, nil
 }
+
+// GetObjectRange fetches length bytes from offset of object from HCP, or
+// of the given version of it if versionID is not empty.
+func (hcp *hcpBackend) GetObjectRange(ctx context.Context, object, versionID string, offset, length int64) (io.ReadCloser, error) {
+	u, err := url.Parse(namespaceURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = object
+	data := url.Values{}
+	if versionID != "" {
+		data.Set("version", versionID)
+	}
+	u.RawQuery = data.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", authToken)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	req.Host = hostHeader
+	resp, err := hcp.Client().Do(req)
+	if debugFlag {
+		console.Println(trace(req, resp))
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the content of a large object is not drained, only error bodies
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		resp.Body.Close()
+		return nil, fmt.Errorf("range requests not supported by HCP for %s", object)
+	case resp.StatusCode != http.StatusPartialContent:
+		closeResponse(resp)
+		return nil, newHCPError(resp)
+	}
+	if resp.ContentLength != length {
+		resp.Body.Close()
+		return nil, fmt.Errorf("expected %d bytes from offset %d of %s, HCP sent %d", length, offset, object, resp.ContentLength)
+	}
+	return resp.Body, nil
+}
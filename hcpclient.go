@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
+	"syscall"
 	"time"
 
+	miniogo "github.com/minio/minio-go/v7"
 	"go.uber.org/atomic"
 )
 
@@ -35,6 +40,9 @@ type hcpBackend struct {
 
 func (hcp *hcpBackend) printLatencyStats() {
 	totReq := int64(hcp.sumLatency.count.Load())
+	if totReq == 0 {
+		return
+	}
 	avgHandshakeLatency := time.Duration(int64(hcp.sumLatency.handshakeLatency.Load()) / totReq)
 	avgttfb := time.Duration(int64(hcp.sumLatency.ttfb.Load()) / totReq)
 	avgdnsLatency := time.Duration(int64(hcp.sumLatency.dnsLatency.Load()) / totReq)
@@ -79,6 +87,88 @@ func (hcp *hcpBackend) Client() *http.Client {
 
 const xHcpErrorMessage = "X-HCP-ErrorMessage"
 
+// hcpError is returned for HCP requests answered with an error status.
+type hcpError struct {
+	statusCode int
+	message    string        // value of X-HCP-ErrorMessage, if any
+	retryAfter time.Duration // value of Retry-After, if any
+}
+
+func newHCPError(resp *http.Response) *hcpError {
+	return &hcpError{
+		statusCode: resp.StatusCode,
+		message:    resp.Header.Get(xHcpErrorMessage),
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter returns the time to wait from a Retry-After header in
+// seconds or as an HTTP date, 0 if there is none.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func (e *hcpError) Error() string {
+	if e.message != "" {
+		return fmt.Sprintf("bad request Status:%d %s", e.statusCode, e.message)
+	}
+	return fmt.Sprintf("bad request Status:%d", e.statusCode)
+}
+
+// retryable returns true if the request may succeed when sent again.
+func (e *hcpError) retryable() bool {
+	switch e.statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return e.statusCode >= http.StatusInternalServerError
+}
+
+// isRetryableError returns true unless err is an HCP error status that
+// will not change by retrying, or the operation was canceled.
+func isRetryableError(err error) bool {
+	var he *hcpError
+	if errors.As(err, &he) {
+		return he.retryable()
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// isTransientError returns true if err is an HCP or MinIO error status
+// that may change by retrying, or a network error. Unlike
+// isRetryableError, any other error is taken to be permanent.
+func isTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var he *hcpError
+	if errors.As(err, &he) {
+		return he.retryable()
+	}
+	var me miniogo.ErrorResponse
+	if errors.As(err, &me) {
+		switch me.Code {
+		case "SlowDown", "RequestTimeout", "InternalError", "ServiceUnavailable", "XMinioServerNotInitialized":
+			return true
+		}
+		return me.StatusCode >= http.StatusInternalServerError
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
 func (hcp *hcpBackend) authenticationToken() string {
 	if hcp.authToken != "" {
 		return hcp.authToken
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	miniogo "github.com/minio/minio-go/v7"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	testCases := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"", 0, 0},
+		{"0", 0, 0},
+		{"-5", 0, 0},
+		{"soon", 0, 0},
+		{"1", time.Second, time.Second},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+		{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 58 * time.Second, time.Minute},
+	}
+	for i, testCase := range testCases {
+		if d := parseRetryAfter(testCase.value); d < testCase.min || d > testCase.max {
+			t.Errorf("Test %d: parseRetryAfter(%q) = %s, want between %s and %s", i+1, testCase.value, d, testCase.min, testCase.max)
+		}
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	testCases := []struct {
+		err       error
+		transient bool
+	}{
+		{&hcpError{statusCode: http.StatusServiceUnavailable}, true},
+		{&hcpError{statusCode: http.StatusTooManyRequests}, true},
+		{&hcpError{statusCode: http.StatusRequestTimeout}, true},
+		{fmt.Errorf("version 1: %w", &hcpError{statusCode: http.StatusInternalServerError}), true},
+		{&hcpError{statusCode: http.StatusNotFound}, false},
+		{&hcpError{statusCode: http.StatusForbidden}, false},
+		{miniogo.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable}, true},
+		{miniogo.ErrorResponse{Code: "InternalError", StatusCode: http.StatusInternalServerError}, true},
+		{miniogo.ErrorResponse{Code: "AccessDenied", StatusCode: http.StatusForbidden}, false},
+		{io.ErrUnexpectedEOF, true},
+		{context.Canceled, false},
+		{errors.New("content SHA-256 00 does not match the 01 reported by HCP"), false},
+		{errObjectSkipped, false},
+	}
+	for i, testCase := range testCases {
+		if transient := isTransientError(testCase.err); transient != testCase.transient {
+			t.Errorf("Test %d: isTransientError(%v) = %t, want %t", i+1, testCase.err, transient, testCase.transient)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const migrationJournalFile = "migration_journal.log"
+
+const (
+	journalListed  = "listed"
+	journalQueued  = "queued"
+	journalDone    = "done"
+	journalFailed  = "failed"
+	journalSkipped = "skipped"
+)
+
+// migrationJournal is an append-only journal kept in --data-dir for each
+// migration run, so that `migrate --resume` migrates exactly the objects
+// that are not done yet, whatever order the workers of the previous run
+// finished them in. Each line of the journal is one of
+//
+//	listing <object listing file>
+//	listed
+//	queued <object path>
+//	done <attempts> <object path>
+//	failed <attempts> <object path>
+//	skipped <attempts> <object path>
+//
+// The last record of an object holds its state, attempts counts the
+// migrations of the object tried by all runs. Skipped objects are tried
+// again by a resumed run, which may be run with other flags. listed is
+// journaled once all objects of the listing are queued. Records are
+// written with O_SYNC, the journal is on disk once an outcome is.
+type migrationJournal struct {
+	mu       sync.Mutex
+	f        *os.File
+	w        *bufio.Writer
+	listFile string
+	// all objects of the listing were queued by a previous run
+	isListed bool
+
+	// state and attempts of the objects journaled by previous runs
+	state    map[string]string
+	attempts map[string]int
+}
+
+func migrationJournalPath() string {
+	return path.Join(dirPath, migrationJournalFile)
+}
+
+// openMigrationJournal returns the journal of the migration of the
+// listing in listFile, and the listing. With --resume the journal of the
+// previous run is resumed, and names the listing if listFile is empty. A
+// dry run only reads the journal, nil is returned if there is none to
+// resume.
+func openMigrationJournal(listFile string) (*migrationJournal, string, error) {
+	if migrateResume {
+		j, err := loadMigrationJournal()
+		if err == nil {
+			if listFile == "" {
+				return j, j.listFile, nil
+			}
+			if abs, _ := filepath.Abs(listFile); abs != j.listFile {
+				j.Close()
+				return nil, "", fmt.Errorf("migration journal %s is for %s, not %s", migrationJournalPath(), j.listFile, listFile)
+			}
+			return j, listFile, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, "", err
+		}
+		if listFile == "" {
+			return nil, "", fmt.Errorf("no migration journal in %s to resume", dirPath)
+		}
+		logMsg(fmt.Sprintf("No migration journal in %s, migrating all of %s", dirPath, listFile))
+	}
+	if dryRun {
+		return nil, listFile, nil
+	}
+	j, err := newMigrationJournal(listFile)
+	return j, listFile, err
+}
+
+// newMigrationJournal starts a new journal for the listing in listFile,
+// replacing the journal of the previous run if it is complete or
+// --overwrite-journal is set.
+func newMigrationJournal(listFile string) (*migrationJournal, error) {
+	abs, err := filepath.Abs(listFile)
+	if err != nil {
+		return nil, err
+	}
+	if !overwriteJournal {
+		if err = checkJournalComplete(); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(migrationJournalPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_SYNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j := &migrationJournal{
+		f:        f,
+		w:        bufio.NewWriter(f),
+		listFile: abs,
+		state:    make(map[string]string),
+		attempts: make(map[string]int),
+	}
+	fmt.Fprintf(j.w, "listing %s\n", abs)
+	if err = j.w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// checkJournalComplete returns an error if the journal of the previous
+// run in --data-dir records objects not migrated yet, or that not all of
+// its listing was queued.
+func checkJournalComplete() error {
+	f, err := os.Open(migrationJournalPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	j, _, err := readMigrationJournal(f)
+	if err != nil {
+		return err
+	}
+	if !j.complete() {
+		return fmt.Errorf("migration journal %s of %s is incomplete, use --resume to continue that migration or --overwrite-journal to start a new one", migrationJournalPath(), j.listFile)
+	}
+	return nil
+}
+
+// loadMigrationJournal reads the journal of the previous run and, unless
+// in a dry run, opens it for appending. A partially written last record
+// is truncated. An error satisfying os.IsNotExist is returned if there is
+// no journal to resume from.
+func loadMigrationJournal() (*migrationJournal, error) {
+	f, err := os.OpenFile(migrationJournalPath(), os.O_RDWR|os.O_SYNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j, offset, err := readMigrationJournal(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	j.f = f
+	if dryRun {
+		f.Close()
+		j.f = nil
+		return j, nil
+	}
+	if err = f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	j.w = bufio.NewWriter(f)
+	return j, nil
+}
+
+// readMigrationJournal replays the records read from r, and returns the
+// journal and the length of its complete records.
+func readMigrationJournal(r io.Reader) (*migrationJournal, int64, error) {
+	j := &migrationJournal{
+		state:    make(map[string]string),
+		attempts: make(map[string]int),
+	}
+	var offset int64
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		offset += int64(len(line))
+		record := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 3)
+		switch {
+		case record[0] == "listing" && len(record) > 1:
+			j.listFile = strings.Join(record[1:], " ")
+		case record[0] == journalListed && len(record) == 1:
+			j.isListed = true
+		case record[0] == journalQueued && len(record) > 1:
+			object := strings.Join(record[1:], " ")
+			if _, ok := j.state[object]; !ok {
+				j.state[object] = journalQueued
+			}
+		case (record[0] == journalDone || record[0] == journalFailed || record[0] == journalSkipped) && len(record) == 3:
+			attempts, err := strconv.Atoi(record[1])
+			if err != nil {
+				continue
+			}
+			j.state[record[2]] = record[0]
+			j.attempts[record[2]] = attempts
+		}
+	}
+	if j.listFile == "" {
+		return nil, 0, fmt.Errorf("migration journal %s does not name an object listing", migrationJournalPath())
+	}
+	return j, offset, nil
+}
+
+// complete returns true if all objects of the listing were queued and
+// none of them is left queued or failed.
+func (j *migrationJournal) complete() bool {
+	if !j.isListed {
+		return false
+	}
+	for _, state := range j.state {
+		if state == journalQueued || state == journalFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// isDone returns true if object was migrated by a previous run.
+func (j *migrationJournal) isDone(object string) bool {
+	return j != nil && j.state[object] == journalDone
+}
+
+// previousAttempts returns the number of migrations of object tried by
+// previous runs.
+func (j *migrationJournal) previousAttempts(object string) int {
+	if j == nil {
+		return 0
+	}
+	return j.attempts[object]
+}
+
+// queued journals object as queued for migration. It is written out with
+// the next outcome journaled.
+func (j *migrationJournal) queued(object string) error {
+	if j == nil || j.w == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err := j.w.WriteString(journalQueued + " " + object + "\n")
+	return err
+}
+
+// listed journals that all objects of the listing are queued.
+func (j *migrationJournal) listed() error {
+	if j == nil || j.w == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.w.WriteString(journalListed + "\n"); err != nil {
+		return err
+	}
+	return j.w.Flush()
+}
+
+// finished journals object as done, as skipped if err is errObjectSkipped
+// or else as failed if err is not nil, after attempts migrations were
+// tried.
+func (j *migrationJournal) finished(object string, attempts int, err error) error {
+	if j == nil || j.w == nil {
+		return nil
+	}
+	state := journalDone
+	switch {
+	case errors.Is(err, errObjectSkipped):
+		state = journalSkipped
+	case err != nil:
+		state = journalFailed
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err = fmt.Fprintf(j.w, "%s %d %s\n", state, attempts, object); err != nil {
+		return err
+	}
+	return j.w.Flush()
+}
+
+func (j *migrationJournal) Close() error {
+	if j == nil || j.f == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.w != nil {
+		if err := j.w.Flush(); err != nil {
+			j.f.Close()
+			return err
+		}
+	}
+	return j.f.Close()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadMigrationJournal(t *testing.T) {
+	testCases := []struct {
+		journal  string
+		state    map[string]string
+		attempts map[string]int
+		offset   int64
+		complete bool
+		success  bool
+	}{
+		{"listing /l\n", map[string]string{}, map[string]int{}, 11, false, true},
+		{"listing /l\nlisted\n", map[string]string{}, map[string]int{}, 18, true, true},
+		// the last record of an object holds its state
+		{"listing /l\nqueued /a\nqueued /b c\nlisted\nfailed 1 /a\ndone 2 /a\ndone 1 /b c\n",
+			map[string]string{"/a": journalDone, "/b c": journalDone},
+			map[string]int{"/a": 2, "/b c": 1}, 74, true, true},
+		{"listing /l\nqueued /a\nqueued /b\nlisted\ndone 1 /a\n",
+			map[string]string{"/a": journalDone, "/b": journalQueued},
+			map[string]int{"/a": 1}, 48, false, true},
+		{"listing /l\nqueued /a\nlisted\nfailed 3 /a\n",
+			map[string]string{"/a": journalFailed},
+			map[string]int{"/a": 3}, 40, false, true},
+		{"listing /l\nqueued /a\nlisted\nskipped 1 /a\n",
+			map[string]string{"/a": journalSkipped},
+			map[string]int{"/a": 1}, 41, true, true},
+		// a queued record written after an outcome does not reset it
+		{"listing /l\ndone 1 /a\nqueued /a\n",
+			map[string]string{"/a": journalDone},
+			map[string]int{"/a": 1}, 31, false, true},
+		// a partially written last record is dropped
+		{"listing /l\nqueued /a\ndone 1 /a\ndone 1 /",
+			map[string]string{"/a": journalDone},
+			map[string]int{"/a": 1}, 31, false, true},
+		{"listing /l\nqueued /a\nlisted\ndo",
+			map[string]string{"/a": journalQueued},
+			map[string]int{}, 28, false, true},
+		// malformed records are ignored
+		{"listing /l\ndone x /a\nbogus\n", map[string]string{}, map[string]int{}, 27, false, true},
+		// no listing
+		{"", nil, nil, 0, false, false},
+		{"queued /a\n", nil, nil, 0, false, false},
+	}
+	for i, testCase := range testCases {
+		j, offset, err := readMigrationJournal(strings.NewReader(testCase.journal))
+		if err != nil && testCase.success {
+			t.Errorf("Test %d: unexpected error %v", i+1, err)
+			continue
+		}
+		if err == nil && !testCase.success {
+			t.Errorf("Test %d: expected an error", i+1)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if j.listFile != "/l" {
+			t.Errorf("Test %d: listing %q, want /l", i+1, j.listFile)
+		}
+		if !reflect.DeepEqual(j.state, testCase.state) {
+			t.Errorf("Test %d: state %v, want %v", i+1, j.state, testCase.state)
+		}
+		if !reflect.DeepEqual(j.attempts, testCase.attempts) {
+			t.Errorf("Test %d: attempts %v, want %v", i+1, j.attempts, testCase.attempts)
+		}
+		if offset != testCase.offset {
+			t.Errorf("Test %d: offset %d, want %d", i+1, offset, testCase.offset)
+		}
+		if j.complete() != testCase.complete {
+			t.Errorf("Test %d: complete() = %t, want %t", i+1, j.complete(), testCase.complete)
+		}
+	}
+}
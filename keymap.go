@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/minio/cli"
+)
+
+var keymapFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "strip-prefix",
+		Usage: "prefix to remove from the HCP path of each object, after /rest/",
+	},
+	cli.StringSliceFlag{
+		Name:  "key-rule",
+		Usage: "rewrite of the object path as REGEX=>REPLACEMENT, with $1 for submatches. May be repeated, all rules apply in order",
+	},
+	cli.StringFlag{
+		Name: "key-template",
+		Usage: "Go template of the object name over .Path, .Dir, .Base, .Name, .Ext, .Segments, .Size and .IngestTime, " +
+			"e.g. '{{.IngestTime.Format \"2006/01\"}}/{{.Base}}'",
+	},
+	cli.StringFlag{
+		Name:  "key-prefix",
+		Usage: "prefix to add to the name of each object on MinIO",
+	},
+}
+
+// keyRule rewrites object paths matching re to repl.
+type keyRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// keyMapper maps HCP object paths to MinIO object names. The path after
+// /rest/ has stripPrefix removed, is rewritten by each rule in turn, then
+// rendered by the template if any, and has prefix added.
+type keyMapper struct {
+	stripPrefix string
+	rules       []keyRule
+	tmpl        *template.Template
+	prefix      string
+}
+
+// unknownSize is the size of objects whose size is not known when they
+// are mapped.
+const unknownSize = -1
+
+// keyTemplateData is the data of the template of --key-template.
+type keyTemplateData struct {
+	Path     string   // object path after --strip-prefix and --key-rule
+	Dir      string   // directory of Path, "." if none
+	Base     string   // last element of Path
+	Name     string   // Base without its extension
+	Ext      string   // extension of Base without the dot
+	Segments []string // elements of Path
+
+	size       int64
+	ingestTime time.Time
+}
+
+// Size returns the size of the object, an error if it is not known so
+// that the object is not mapped to a name for size 0.
+func (d keyTemplateData) Size() (int64, error) {
+	if d.size == unknownSize {
+		return 0, errors.New("size of the object is not known")
+	}
+	return d.size, nil
+}
+
+// IngestTime returns the ingest time of the object, an error if it is not
+// known.
+func (d keyTemplateData) IngestTime() (time.Time, error) {
+	if d.ingestTime.IsZero() {
+		return d.ingestTime, errors.New("ingest time of the object is not known")
+	}
+	return d.ingestTime, nil
+}
+
+// keyMap is set by the key mapping flags, nil if objects keep their HCP
+// path as name.
+var keyMap *keyMapper
+
+// parseKeyMapFlags returns the key mapper set by the key mapping flags,
+// or nil if none are set.
+func parseKeyMapFlags(ctx *cli.Context) (*keyMapper, error) {
+	m := &keyMapper{
+		stripPrefix: ctx.String("strip-prefix"),
+		prefix:      ctx.String("key-prefix"),
+	}
+	for _, r := range ctx.StringSlice("key-rule") {
+		kv := strings.SplitN(r, "=>", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid --key-rule %s, must be REGEX=>REPLACEMENT", r)
+		}
+		re, err := regexp.Compile(kv[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid --key-rule %s: %w", r, err)
+		}
+		m.rules = append(m.rules, keyRule{re: re, repl: kv[1]})
+	}
+	if t := ctx.String("key-template"); t != "" {
+		tmpl, err := parseKeyTemplate(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --key-template: %w", err)
+		}
+		m.tmpl = tmpl
+	}
+	if m.stripPrefix == "" && m.prefix == "" && len(m.rules) == 0 && m.tmpl == nil {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// parseKeyTemplate parses the template t of --key-template.
+func parseKeyTemplate(t string) (*template.Template, error) {
+	return template.New("key").Option("missingkey=error").Funcs(template.FuncMap{
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+	}).Parse(t)
+}
+
+// mapKey returns the MinIO object name of the HCP object at object with
+// the given size and ingest time, unknownSize and the zero time if they
+// are not known.
+func (m *keyMapper) mapKey(object string, size int64, ingestTime time.Time) (string, error) {
+	key := strings.TrimPrefix(minioObjectName(object), m.stripPrefix)
+	for _, r := range m.rules {
+		key = r.re.ReplaceAllString(key, r.repl)
+	}
+	if m.tmpl != nil {
+		base := path.Base(key)
+		ext := path.Ext(base)
+		data := keyTemplateData{
+			Path:       key,
+			Dir:        path.Dir(key),
+			Base:       base,
+			Name:       strings.TrimSuffix(base, ext),
+			Ext:        strings.TrimPrefix(ext, "."),
+			Segments:   strings.Split(strings.Trim(key, "/"), "/"),
+			size:       size,
+			ingestTime: ingestTime,
+		}
+		var b strings.Builder
+		if err := m.tmpl.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("unable to map %s: %w", object, err)
+		}
+		key = b.String()
+	}
+	key = m.prefix + strings.TrimPrefix(key, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		return "", fmt.Errorf("%s maps to invalid object name %q", object, key)
+	}
+	return key, nil
+}
+
+// objectKey returns the MinIO object name of the HCP object of entry with
+// the given size, taking the ingest time from the headers h of a GET on
+// it if there are any.
+func objectKey(entry Entry, size int64, h http.Header) (string, error) {
+	if keyMap == nil {
+		return minioObjectName(entry.ObjectPath), nil
+	}
+	ingest := entry.IngestTime
+	if v := h.Get("X-HCP-IngestTime"); v != "" {
+		if t, err := strconv.ParseInt(v, 10, 64); err == nil {
+			ingest = t
+		}
+	}
+	var ingestTime time.Time
+	if ingest > 0 {
+		ingestTime = time.Unix(ingest, 0).UTC()
+	}
+	return keyMap.mapKey(entry.ObjectPath, size, ingestTime)
+}
+
+// listedSize returns the size of the object of entry as listed, unknown
+// for entries of text listings.
+func listedSize(entry Entry) int64 {
+	if entry.EntryType == "" {
+		return unknownSize
+	}
+	return entry.Size
+}
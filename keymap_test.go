@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestMapKey(t *testing.T) {
+	june := time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		stripPrefix string
+		rules       []string
+		template    string
+		prefix      string
+		object      string
+		size        int64
+		ingestTime  time.Time
+		key         string
+		success     bool
+	}{
+		// path mapping
+		{"", nil, "", "", "/rest/a/b.pdf", 1, june, "a/b.pdf", true},
+		{"archive/", nil, "", "", "/rest/archive/a/b.pdf", 1, june, "a/b.pdf", true},
+		{"", nil, "", "hcp/", "/rest/a/b.pdf", 1, june, "hcp/a/b.pdf", true},
+		{"", []string{`^reports/(\d{4})/`, "$1/reports/"}, "", "", "/rest/reports/2020/x.pdf", 1, june, "2020/reports/x.pdf", true},
+		{"", []string{`\.PDF$`, ".pdf", `^a/`, "b/"}, "", "", "/rest/a/x.PDF", 1, june, "b/x.pdf", true},
+		// templates
+		{"", nil, "{{.Dir}}/{{.Name}}.{{lower .Ext}}", "", "/rest/a/B.PDF", 1, june, "a/B.pdf", true},
+		{"", nil, "{{index .Segments 1}}/{{.Base}}", "", "/rest/a/b/c.txt", 1, june, "b/c.txt", true},
+		{"", nil, `{{.IngestTime.Format "2006/01"}}/{{.Path}}`, "", "/rest/a/b.pdf", 1, june, "2020/06/a/b.pdf", true},
+		{"", nil, "{{.Size}}/{{.Base}}", "", "/rest/a/b.pdf", 0, june, "0/b.pdf", true},
+		{"", nil, "{{.Size}}/{{.Base}}", "", "/rest/a/b.pdf", 2048, time.Time{}, "2048/b.pdf", true},
+		// attributes that are not known fail the object
+		{"", nil, "{{.Size}}/{{.Base}}", "", "/rest/a/b.pdf", unknownSize, june, "", false},
+		{"", nil, `{{.IngestTime.Format "2006"}}/{{.Base}}`, "", "/rest/a/b.pdf", 1, time.Time{}, "", false},
+		// only the attributes used need to be known
+		{"", nil, "{{.Base}}", "", "/rest/a/b.pdf", unknownSize, time.Time{}, "b.pdf", true},
+		// invalid names
+		{"", nil, "{{.Dir}}/", "", "/rest/a/b.pdf", 1, june, "", false},
+		{"", []string{".*", ""}, "", "", "/rest/a/b.pdf", 1, june, "", false},
+	}
+	for i, testCase := range testCases {
+		m := &keyMapper{stripPrefix: testCase.stripPrefix, prefix: testCase.prefix}
+		for j := 0; j < len(testCase.rules); j += 2 {
+			m.rules = append(m.rules, keyRule{re: regexp.MustCompile(testCase.rules[j]), repl: testCase.rules[j+1]})
+		}
+		if testCase.template != "" {
+			tmpl, err := parseKeyTemplate(testCase.template)
+			if err != nil {
+				t.Fatalf("Test %d: %v", i+1, err)
+			}
+			m.tmpl = tmpl
+		}
+		key, err := m.mapKey(testCase.object, testCase.size, testCase.ingestTime)
+		if err != nil && testCase.success {
+			t.Errorf("Test %d: unexpected error %v", i+1, err)
+			continue
+		}
+		if err == nil && !testCase.success {
+			t.Errorf("Test %d: expected an error, got %s", i+1, key)
+			continue
+		}
+		if key != testCase.key {
+			t.Errorf("Test %d: mapped %s to %q, want %q", i+1, testCase.object, key, testCase.key)
+		}
+	}
+}
+
+func TestObjectKeyIngestTime(t *testing.T) {
+	tmpl, err := parseKeyTemplate(`{{.IngestTime.Format "2006"}}/{{.Base}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(m *keyMapper) { keyMap = m }(keyMap)
+	keyMap = &keyMapper{tmpl: tmpl}
+
+	testCases := []struct {
+		entry   Entry
+		header  http.Header
+		key     string
+		success bool
+	}{
+		{Entry{ObjectPath: "/rest/a", IngestTime: 1593000000}, nil, "2020/a", true},
+		{Entry{ObjectPath: "/rest/a", IngestTime: 1593000000}, http.Header{"X-Hcp-Ingesttime": {"1136214245"}}, "2006/a", true},
+		{Entry{ObjectPath: "/rest/a"}, http.Header{"X-Hcp-Ingesttime": {"1136214245"}}, "2006/a", true},
+		{Entry{ObjectPath: "/rest/a"}, nil, "", false},
+	}
+	for i, testCase := range testCases {
+		key, err := objectKey(testCase.entry, 1, testCase.header)
+		if (err == nil) != testCase.success || key != testCase.key {
+			t.Errorf("Test %d: objectKey = %q, %v, want %q", i+1, key, err, testCase.key)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/minio/cli"
+	miniogo "github.com/minio/minio-go/v7"
+)
+
+var largeObjectFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "multipart-threshold",
+		Usage: "size from which objects are fetched with parallel range requests and uploaded in parts, 0 to never",
+		Value: "1GiB",
+	},
+	cli.StringFlag{
+		Name:  "part-size",
+		Usage: "size of the ranges and parts of large objects",
+		Value: "128MiB",
+	},
+	cli.IntFlag{
+		Name:  "part-workers",
+		Usage: "number of parts of a large object to migrate in parallel, one with --verify to hash them in order",
+		Value: 4,
+	},
+}
+
+const (
+	minPartSize = 5 * humanize.MiByte
+	maxParts    = 10000
+
+	// largeUploadsFile in --data-dir records the multipart uploads of
+	// large objects in flight, or left by objects that failed, so that a
+	// later run resumes them instead of starting over.
+	largeUploadsFile = "multipart_uploads.json"
+)
+
+var (
+	multipartThreshold int64
+	partSize           int64
+	partWorkers        int
+)
+
+// parseLargeObjectFlags sets the multipart threshold, part size and
+// workers from --multipart-threshold, --part-size and --part-workers.
+func parseLargeObjectFlags(ctx *cli.Context) error {
+	threshold, err := humanize.ParseBytes(ctx.String("multipart-threshold"))
+	if err != nil {
+		return fmt.Errorf("invalid --multipart-threshold %s: %w", ctx.String("multipart-threshold"), err)
+	}
+	size, err := humanize.ParseBytes(ctx.String("part-size"))
+	if err != nil {
+		return fmt.Errorf("invalid --part-size %s: %w", ctx.String("part-size"), err)
+	}
+	if size < minPartSize {
+		return fmt.Errorf("--part-size must be at least %s", humanize.IBytes(minPartSize))
+	}
+	multipartThreshold, partSize, partWorkers = int64(threshold), int64(size), ctx.Int("part-workers")
+	if partWorkers < 1 {
+		partWorkers = 1
+	}
+	return nil
+}
+
+// isLargeObject returns true if oi is to be migrated in parts.
+func isLargeObject(oi miniogo.ObjectInfo) bool {
+	return multipartThreshold > 0 && oi.Size >= multipartThreshold
+}
+
+// largeUpload is the multipart upload of a large HCP object to MinIO. It
+// outlives a failed attempt to migrate the object, and the run itself
+// through largeUploadsFile, so that a retry only fetches and uploads the
+// parts that are missing.
+type largeUpload struct {
+	object    string // HCP path
+	versionID string
+	bucket    string
+	key       string
+	size      int64
+	partSize  int64
+	uploadID  string
+	initiated time.Time
+	// resumed is set for an upload recorded by a previous run, whose parts
+	// are to be listed from MinIO before it is resumed.
+	resumed bool
+
+	mu    sync.Mutex
+	parts []miniogo.CompletePart // part i+1 at i, no ETag until uploaded
+
+	// With --verify, the content is hashed in order as parts are fetched,
+	// which are then migrated one after the other. hashed is the number
+	// of parts hashed so far. The hash is not recorded, all parts of a
+	// resumed upload are fetched again to hash them.
+	h      hash.Hash
+	scheme string
+	want   []byte
+	hashed int
+}
+
+// largeUploadPart is an uploaded part in largeUploadsFile.
+type largeUploadPart struct {
+	PartNumber int    `json:"partNumber"`
+	ETag       string `json:"etag"`
+}
+
+// largeUploadRecord is a largeUpload in largeUploadsFile.
+type largeUploadRecord struct {
+	Object    string            `json:"object"`
+	VersionID string            `json:"versionId,omitempty"`
+	Bucket    string            `json:"bucket"`
+	Key       string            `json:"key"`
+	Size      int64             `json:"size"`
+	PartSize  int64             `json:"partSize"`
+	UploadID  string            `json:"uploadId"`
+	Initiated time.Time         `json:"initiated"`
+	Parts     []largeUploadPart `json:"parts,omitempty"`
+}
+
+var (
+	largeUploadsMu sync.Mutex
+	largeUploads   = make(map[string]*largeUpload)
+)
+
+// loadLargeUploads replaces the uploads in flight with the uploads
+// recorded in --data-dir by a previous run.
+func loadLargeUploads() error {
+	largeUploadsMu.Lock()
+	defer largeUploadsMu.Unlock()
+	largeUploads = make(map[string]*largeUpload)
+	records, err := readLargeUploads(dirPath)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		u := &largeUpload{
+			object:    rec.Object,
+			versionID: rec.VersionID,
+			bucket:    rec.Bucket,
+			key:       rec.Key,
+			size:      rec.Size,
+			partSize:  rec.PartSize,
+			uploadID:  rec.UploadID,
+			initiated: rec.Initiated,
+			resumed:   true,
+		}
+		if u.partSize <= 0 || u.uploadID == "" {
+			continue
+		}
+		u.parts = make([]miniogo.CompletePart, (u.size+u.partSize-1)/u.partSize)
+		for _, p := range rec.Parts {
+			if p.PartNumber >= 1 && p.PartNumber <= len(u.parts) {
+				u.parts[p.PartNumber-1] = miniogo.CompletePart{PartNumber: p.PartNumber, ETag: p.ETag}
+			}
+		}
+		largeUploads[u.bucket+"/"+u.key] = u
+	}
+	if len(largeUploads) > 0 {
+		logMsg(fmt.Sprintf("Resuming %d multipart uploads recorded in %s", len(largeUploads), largeUploadsFile))
+	}
+	return nil
+}
+
+// readLargeUploads returns the uploads recorded in largeUploadsFile in
+// dir, if any.
+func readLargeUploads(dir string) ([]largeUploadRecord, error) {
+	b, err := ioutil.ReadFile(path.Join(dir, largeUploadsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var records []largeUploadRecord
+	if err = json.Unmarshal(b, &records); err != nil {
+		return nil, fmt.Errorf("malformed %s: %w", largeUploadsFile, err)
+	}
+	return records, nil
+}
+
+// writeLargeUploads records uploads in largeUploadsFile in dir, replacing
+// it as a whole so that it is never left half written. The file is
+// removed if there are no uploads.
+func writeLargeUploads(dir string, records []largeUploadRecord) error {
+	name := path.Join(dir, largeUploadsFile)
+	if len(records) == 0 {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(name+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// saveLargeUploads records the uploads in flight in --data-dir.
+func saveLargeUploads() error {
+	largeUploadsMu.Lock()
+	defer largeUploadsMu.Unlock()
+	records := make([]largeUploadRecord, 0, len(largeUploads))
+	for _, u := range largeUploads {
+		if u.uploadID == "" {
+			continue
+		}
+		records = append(records, u.record())
+	}
+	return writeLargeUploads(dirPath, records)
+}
+
+// record returns the record of u in largeUploadsFile.
+func (u *largeUpload) record() largeUploadRecord {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	rec := largeUploadRecord{
+		Object:    u.object,
+		VersionID: u.versionID,
+		Bucket:    u.bucket,
+		Key:       u.key,
+		Size:      u.size,
+		PartSize:  u.partSize,
+		UploadID:  u.uploadID,
+		Initiated: u.initiated,
+	}
+	for _, p := range u.parts {
+		if p.ETag != "" {
+			rec.Parts = append(rec.Parts, largeUploadPart{PartNumber: p.PartNumber, ETag: p.ETag})
+		}
+	}
+	return rec
+}
+
+// getLargeUpload returns the upload of the object oi from a previous
+// attempt or run, if it is for the same version and size, or a new one.
+func getLargeUpload(object string, oi miniogo.ObjectInfo) (*largeUpload, error) {
+	largeUploadsMu.Lock()
+	defer largeUploadsMu.Unlock()
+	id := minioBucket + "/" + oi.Key
+	u, ok := largeUploads[id]
+	if ok && (u.object != object || u.versionID != oi.VersionID || u.size != oi.Size) {
+		delete(largeUploads, id)
+		go abortLargeUpload(context.Background(), u)
+		ok = false
+	}
+	if !ok {
+		ps := partSize
+		if n := (oi.Size + ps - 1) / ps; n > maxParts {
+			ps = (oi.Size + maxParts - 1) / maxParts
+		}
+		u = &largeUpload{
+			object:    object,
+			versionID: oi.VersionID,
+			bucket:    minioBucket,
+			key:       oi.Key,
+			size:      oi.Size,
+			partSize:  ps,
+			parts:     make([]miniogo.CompletePart, (oi.Size+ps-1)/ps),
+		}
+	}
+	if verifyContent && u.h == nil {
+		var err error
+		if u.scheme, u.h, u.want, err = parseHCPHash(oi.Metadata.Get(hcpHashHeader)); err != nil {
+			return nil, err
+		}
+	}
+	largeUploads[id] = u
+	return u, nil
+}
+
+// dropLargeUpload forgets the upload u, aborting it on MinIO unless it
+// completed.
+func dropLargeUpload(ctx context.Context, u *largeUpload) {
+	largeUploadsMu.Lock()
+	if largeUploads[u.bucket+"/"+u.key] == u {
+		delete(largeUploads, u.bucket+"/"+u.key)
+	}
+	largeUploadsMu.Unlock()
+	abortLargeUpload(ctx, u)
+	if err := saveLargeUploads(); err != nil {
+		logDMsg("unable to record multipart uploads", err)
+	}
+}
+
+func abortLargeUpload(ctx context.Context, u *largeUpload) {
+	if u.uploadID == "" {
+		return
+	}
+	core := miniogo.Core{Client: minioClient}
+	if err := core.AbortMultipartUpload(ctx, u.bucket, u.key, u.uploadID); err != nil {
+		logDMsg("unable to abort upload of "+u.key, err)
+	}
+}
+
+// closeLargeUploads forgets the uploads left by objects that failed. They
+// stay recorded in --data-dir for the next run to resume, or for
+// cleanup-uploads to abort.
+func closeLargeUploads() {
+	largeUploadsMu.Lock()
+	n := len(largeUploads)
+	largeUploads = make(map[string]*largeUpload)
+	largeUploadsMu.Unlock()
+	if n > 0 {
+		logMsg(fmt.Sprintf("%d incomplete multipart uploads recorded in %s for the next run", n, largeUploadsFile))
+	}
+}
+
+// resume lists the parts MinIO has for an upload recorded by a previous
+// run, which replace the recorded ones. The upload starts over if MinIO
+// no longer has it.
+func (u *largeUpload) resume(ctx context.Context, core miniogo.Core) error {
+	parts := make([]miniogo.CompletePart, len(u.parts))
+	marker := 0
+	for {
+		res, err := core.ListObjectParts(ctx, u.bucket, u.key, u.uploadID, marker, 1000)
+		if err != nil {
+			var me miniogo.ErrorResponse
+			if !errors.As(err, &me) || me.Code != "NoSuchUpload" {
+				return err
+			}
+			logDMsg(fmt.Sprintf("upload %s of %s is gone, starting over", u.uploadID, u.key), nil)
+			u.uploadID = ""
+			break
+		}
+		for _, p := range res.ObjectParts {
+			if i := p.PartNumber - 1; i >= 0 && i < len(parts) && p.Size == u.partLength(i) {
+				parts[i] = miniogo.CompletePart{PartNumber: p.PartNumber, ETag: p.ETag}
+			}
+		}
+		if !res.IsTruncated {
+			break
+		}
+		marker = res.NextPartNumberMarker
+	}
+	u.mu.Lock()
+	u.parts = parts
+	u.resumed = false
+	u.mu.Unlock()
+	return nil
+}
+
+// partLength returns the length of part i.
+func (u *largeUpload) partLength(i int) int64 {
+	offset := int64(i) * u.partSize
+	if offset+u.partSize > u.size {
+		return u.size - offset
+	}
+	return u.partSize
+}
+
+// uploadLargeObject migrates the HCP object described by oi to MinIO
+// with partWorkers parallel range requests, each streamed to MinIO as a
+// part. The upload is kept for a retry, and recorded for the next run, if
+// it fails with a transient error.
+func uploadLargeObject(ctx context.Context, object string, oi miniogo.ObjectInfo, opts miniogo.PutObjectOptions) (err error) {
+	u, err := getLargeUpload(object, oi)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil && !isTransientError(err) {
+			dropLargeUpload(context.Background(), u)
+		}
+	}()
+	core := miniogo.Core{Client: minioClient}
+	if u.resumed {
+		if err = u.resume(ctx, core); err != nil {
+			return err
+		}
+	}
+	if u.uploadID == "" {
+		if u.h != nil {
+			opts.UserMetadata[verifiedHashMeta] = u.scheme + " " + fmt.Sprintf("%x", u.want)
+		}
+		if u.uploadID, err = core.NewMultipartUpload(ctx, u.bucket, u.key, opts); err != nil {
+			return err
+		}
+		u.initiated = time.Now().UTC()
+		if err = saveLargeUploads(); err != nil {
+			return err
+		}
+	}
+
+	// parts are hashed in order, so one at a time
+	workers := partWorkers
+	if u.h != nil {
+		workers = 1
+	}
+	// On the first error no more parts are started, the parts in flight
+	// are finished so that a retry has less left to do.
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		failed   = make(chan struct{})
+	)
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := u.migratePart(ctx, core, i); err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("part %d: %w", i+1, err)
+						close(failed)
+					})
+				}
+			}
+		}()
+	}
+sendJobs:
+	for i := range u.parts {
+		select {
+		case jobs <- i:
+		case <-failed:
+			break sendJobs
+		case <-ctx.Done():
+			break sendJobs
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if u.h != nil {
+		if got := u.h.Sum(nil); !bytes.Equal(got, u.want) {
+			return fmt.Errorf("content %s %X does not match the %X reported by HCP", u.scheme, got, u.want)
+		}
+	}
+	if _, err = core.CompleteMultipartUpload(ctx, u.bucket, u.key, u.uploadID, u.parts); err != nil {
+		return err
+	}
+	largeUploadsMu.Lock()
+	delete(largeUploads, u.bucket+"/"+u.key)
+	largeUploadsMu.Unlock()
+	if err = saveLargeUploads(); err != nil {
+		logDMsg("unable to record multipart uploads", err)
+	}
+	logDMsg(fmt.Sprintf("Uploaded %s in %d parts successfully", u.key, len(u.parts)), nil)
+	return nil
+}
+
+// migratePart fetches part i from HCP, unless it is uploaded and needs no
+// hashing, and streams it to MinIO unless it already is uploaded. With
+// --verify it is hashed on the way, parts are migrated in order then.
+func (u *largeUpload) migratePart(ctx context.Context, core miniogo.Core, i int) (err error) {
+	u.mu.Lock()
+	uploaded := u.parts[i].ETag != ""
+	needHash := u.h != nil && i >= u.hashed
+	u.mu.Unlock()
+	if uploaded && !needHash {
+		return nil
+	}
+	length := u.partLength(i)
+	r, err := hcp.GetObjectRange(ctx, u.object, u.versionID, int64(i)*u.partSize, length)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if needHash {
+		// the hash is taken back to the end of the previous part if this
+		// part fails half way
+		var state []byte
+		if state, err = u.h.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				if uerr := u.h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); uerr != nil {
+					err = uerr
+				}
+			}
+		}()
+		if uploaded {
+			_, err = io.CopyN(u.h, r, length)
+			if err == nil {
+				u.markHashed(i)
+			}
+			return err
+		}
+		r = readCloser{io.TeeReader(r, u.h), r}
+	}
+	part, err := core.PutObjectPart(ctx, u.bucket, u.key, u.uploadID, i+1, r, length, "", "", nil)
+	if err != nil {
+		return err
+	}
+	u.mu.Lock()
+	u.parts[i] = miniogo.CompletePart{PartNumber: i + 1, ETag: part.ETag}
+	u.mu.Unlock()
+	if needHash {
+		u.markHashed(i)
+	}
+	return saveLargeUploads()
+}
+
+// markHashed records that the content is hashed up to the end of part i.
+func (u *largeUpload) markHashed(i int) {
+	u.mu.Lock()
+	u.hashed = i + 1
+	u.mu.Unlock()
+}
+
+// readCloser reads from a Reader and closes a Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	miniogo "github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func TestMigratePartHashRollback(t *testing.T) {
+	const size, partLen = 2500, 1000
+	content := bytes.Repeat([]byte("0123456789abcdefghij"), size/20)
+	want := sha256.Sum256(content)
+
+	defer func(u string, b *hcpBackend, d string) { namespaceURL, hcp, dirPath = u, b, d }(namespaceURL, hcp, dirPath)
+	dirPath = t.TempDir()
+
+	// HCP fails the range of failOffset once, half way through its content
+	var failOffset, failures int64
+	hcpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		if start == atomic.LoadInt64(&failOffset) && atomic.AddInt64(&failures, 1) == 1 {
+			w.Write(content[start : start+(end-start+1)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Write(content[start : end+1])
+	}))
+	defer hcpSrv.Close()
+	namespaceURL = hcpSrv.URL + "/rest"
+	hcp = &hcpBackend{URL: namespaceURL, client: hcpSrv.Client()}
+
+	// MinIO accepts every part it receives in full
+	s3Srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil || int64(len(b)) != r.ContentLength {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("ETag", `"etag-`+r.URL.Query().Get("partNumber")+`"`)
+	}))
+	defer s3Srv.Close()
+	s3URL, _ := url.Parse(s3Srv.URL)
+	client, err := miniogo.New(s3URL.Host, &miniogo.Options{Creds: credentials.NewStaticV4("a", "b", ""), Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	core := miniogo.Core{Client: client}
+
+	testCases := []struct {
+		failPart int
+		uploaded bool // the parts are uploaded and only need hashing
+	}{
+		{0, false},
+		{1, false},
+		{2, false},
+		{1, true},
+		{2, true},
+	}
+	for i, testCase := range testCases {
+		atomic.StoreInt64(&failOffset, int64(testCase.failPart)*partLen)
+		atomic.StoreInt64(&failures, 0)
+		u := &largeUpload{
+			object:   "/rest/big",
+			bucket:   "bucket",
+			key:      "big",
+			size:     size,
+			partSize: partLen,
+			uploadID: "upload",
+			parts:    make([]miniogo.CompletePart, 3),
+			h:        sha256.New(),
+		}
+		if testCase.uploaded {
+			for j := range u.parts {
+				u.parts[j] = miniogo.CompletePart{PartNumber: j + 1, ETag: "uploaded"}
+			}
+		}
+		for j := range u.parts {
+			err := u.migratePart(context.Background(), core, j)
+			if (err != nil) != (j == testCase.failPart) {
+				t.Fatalf("Test %d: part %d failed with %v", i+1, j+1, err)
+			}
+			if err != nil {
+				if err = u.migratePart(context.Background(), core, j); err != nil {
+					t.Fatalf("Test %d: part %d failed again with %v", i+1, j+1, err)
+				}
+			}
+		}
+		if got := u.h.Sum(nil); !bytes.Equal(got, want[:]) {
+			t.Errorf("Test %d: digest %x, want %x", i+1, got, want)
+		}
+		if u.hashed != len(u.parts) {
+			t.Errorf("Test %d: %d parts hashed, want %d", i+1, u.hashed, len(u.parts))
+		}
+		for j, p := range u.parts {
+			if p.ETag == "" || (!testCase.uploaded && !strings.Contains(p.ETag, fmt.Sprint(j+1))) {
+				t.Errorf("Test %d: part %d has ETag %q", i+1, j+1, p.ETag)
+			}
+		}
+	}
+}
@@ -22,6 +22,9 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/minio/cli"
@@ -63,6 +66,77 @@ var allFlags = []cli.Flag{
 		Usage: "file with list of child prefixes under namespace url",
 	},
 }
+var listFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "mode",
+		Usage: "how to enumerate objects, 'walk' to list directories recursively or 'query' to page through the HCP metadata query API",
+		Value: listModeWalk,
+	},
+	cli.IntFlag{
+		Name:  "list-workers",
+		Usage: "number of directories to list in parallel",
+		Value: 8,
+	},
+	cli.StringFlag{
+		Name:  "format",
+		Usage: "format of the object listing, 'text' for one object path per line or 'jsonl' for all HCP attributes of each object",
+		Value: listFormatText,
+	},
+	cli.BoolFlag{
+		Name:  "versions",
+		Usage: "list every version of each object, requires --format jsonl",
+	},
+	cli.StringFlag{
+		Name:  "since",
+		Usage: "only list objects created or changed since a timestamp (RFC3339 or YYYY-MM-DD) or since a previous listing file was taken",
+	},
+	cli.StringFlag{
+		Name:  "until",
+		Usage: "only list objects created or changed before a timestamp (RFC3339 or YYYY-MM-DD)",
+	},
+	cli.BoolFlag{
+		Name:  "prune-unchanged",
+		Usage: "with --since, skip sub directories whose own change time is before the cutoff",
+	},
+	cli.StringFlag{
+		Name:  "query-url",
+		Usage: "URL of the HCP metadata query API for --mode query, defaults to /query on the namespace URL host",
+	},
+	cli.StringFlag{
+		Name:  "query-host-header",
+		Usage: "host header of the tenant for --mode query, defaults to --host-header without the namespace name",
+	},
+	cli.StringFlag{
+		Name:  "query-namespace",
+		Usage: "namespace to query as namespace.tenant for --mode query, defaults to the first two labels of --host-header",
+	},
+	cli.IntFlag{
+		Name:  "query-page-size",
+		Usage: "number of objects to request per page for --mode query",
+		Value: 1000,
+	},
+	cli.IntFlag{
+		Name:  "list-retries",
+		Usage: "number of times to retry listing a directory before recording it as failed",
+		Value: 5,
+	},
+	cli.BoolFlag{
+		Name:  "resume",
+		Usage: "resume an interrupted listing from the checkpoint in --data-dir",
+	},
+	planFileFlag,
+	cli.IntFlag{
+		Name:  "shards",
+		Usage: "split the complete listing into this many manifest files, one for each migrate host",
+		Value: 1,
+	},
+	cli.StringFlag{
+		Name:  "shard-by",
+		Usage: "how to split the listing with --shards, 'hash' of the object path or 'size' for equal bytes, which requires --format jsonl",
+		Value: shardByHash,
+	},
+}
+
 var (
 	authToken          string
 	hostHeader         string
@@ -72,25 +146,47 @@ var (
 	bucket             string // HCP bucket name
 	minioBucket        string // in case user needs a different bucket name on MinIO
 	debugFlag, logFlag bool
+	listWorkers        int
+	listFormat         string
+	listResume         bool
+	listRetries        int
+	listVersions       bool
+	listMode           string
+	listSince          time.Time
+	listUntil          time.Time
+	listPruneUnchanged bool
+	queryURL           string
+	queryHostHeader    string
+	queryNamespaceName string
+	queryPageSize      int
+	listShards         int
+	listShardBy        string
 	hcp                *hcpBackend
 )
 
 const (
-	objListFile = "object_listing.txt"
-	failMigFile = "migration_fails.txt"
-	logMigFile  = "migration_success.txt"
+	listModeWalk  = "walk"
+	listModeQuery = "query"
+)
+
+const (
+	objListFile     = "object_listing.txt"
+	objListJSONFile = "object_listing.jsonl"
+	failMigFile     = "migration_fails.txt"
+	logMigFile      = "migration_success.txt"
+	listFailFile    = "listing_fails.txt"
 )
 
 var listCmd = cli.Command{
 	Name:   "list",
 	Usage:  "List objects in HCP namespace and download to disk",
 	Action: listAction,
-	Flags:  allFlags,
+	Flags:  append(append(append(allFlags, listFlags...), filterFlags...), symlinkFlags...),
 	CustomHelpTemplate: `NAME:
   {{.HelpName}} - {{.Usage}}
 
 USAGE:
-  {{.HelpName}} --auth-token --namespace-url --host-header --dir
+  {{.HelpName}} --auth-token --namespace-url --host-header --dir [--mode, --list-workers, --format, --versions, --since, --until, --list-retries, --resume, --include, --exclude, --min-size, --max-size, --ingested-before, --ingested-after, --symlinks, --shards, --shard-by, --plan-file]
 
 FLAGS:
   {{range .VisibleFlags}}{{.}}
@@ -104,6 +200,45 @@ EXAMPLES:
 2. List objects in HCP namespace https://hcp-vip.example.com for top level prefixes in prefixFile and download list to /tmp/data
    $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
 		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" --prefixes-file /tmp/data/input-prefix-list.txt
+
+3. List objects in HCP namespace https://hcp-vip.example.com with 64 directories listed in parallel
+   $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" --list-workers 64
+
+4. List objects in HCP namespace https://hcp-vip.example.com with all HCP attributes of each object as JSON lines
+   $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" --format jsonl
+
+5. List every version of each object in HCP namespace https://hcp-vip.example.com
+   $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" --format jsonl --versions
+
+6. List objects in HCP namespace https://hcp-vip.example.com created or changed since a previous listing was taken
+   $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--since /tmp/data/object_listing.txt.10-01-2021-09-30-00
+
+7. List objects in HCP namespace https://hcp-vip.example.com changed in 2020 using the metadata query API
+   $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--mode query --since 2020-01-01 --until 2021-01-01
+
+8. Resume an interrupted listing of HCP namespace https://hcp-vip.example.com, appending to the listing in /tmp/data
+   $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" --resume
+
+9. List objects in HCP namespace https://hcp-vip.example.com under images/ of at least 1MiB, leaving out thumbnails
+   $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--include "images/**" --exclude "**/thumbs/**" --min-size 1MiB
+
+10. List objects in HCP namespace https://hcp-vip.example.com and report its symbolic links with their targets
+   $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" --symlinks report
+
+11. List objects in HCP namespace https://hcp-vip.example.com into 4 manifest files of about equal bytes for 4 migrate hosts
+   $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com" \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" --format jsonl --shards 4 --shard-by size
 		  
 `,
 }
@@ -122,8 +257,9 @@ func checkArgsAndInit(ctx *cli.Context) {
 
 	dirPath = ctx.String("data-dir")
 	//	bucket = ctx.String("bucket")
+	planFilePath = ctx.String("plan-file")
 
-	if authToken == "" || hostHeader == "" || namespaceURL == "" {
+	if authToken == "" || ((hostHeader == "" || namespaceURL == "") && planFilePath == "") {
 		cli.ShowCommandHelp(ctx, ctx.Command.Name) // last argument is exit code
 		console.Fatalln(fmt.Errorf("--auth-token, --host-header, --namespace-url and --data-dir required"))
 		return
@@ -164,10 +300,117 @@ func readLines(path string) ([]string, error) {
 	return lines, scanner.Err()
 }
 
+// parseSince returns the cutoff time for --since, given as a timestamp
+// or as the name of a previous object listing, in which case it is the
+// time that listing was started as recorded in its file name.
+func parseSince(since string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", since, time.Local); err == nil {
+		return t, nil
+	}
+	if _, err := os.Stat(since); err != nil {
+		return time.Time{}, fmt.Errorf("neither a timestamp nor a previous listing: %w", err)
+	}
+	name := path.Base(since)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		if t, err := time.ParseInLocation("01-02-2006-15-04-05", name[i+1:], time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to tell when listing %s was taken from its name", since)
+}
+
 func listAction(cliCtx *cli.Context) error {
 	checkArgsAndInit(cliCtx)
 	ctx := context.Background()
 	inputPrefixFile = cliCtx.String("prefixes-file")
+	listWorkers = cliCtx.Int("list-workers")
+	if listWorkers <= 0 {
+		console.Fatalln(fmt.Errorf("--list-workers must be greater than 0"))
+	}
+	listFormat = cliCtx.String("format")
+	if listFormat != listFormatText && listFormat != listFormatJSONL {
+		console.Fatalln(fmt.Errorf("--format must be one of %s or %s", listFormatText, listFormatJSONL))
+	}
+	listResume = cliCtx.Bool("resume")
+	listRetries = cliCtx.Int("list-retries")
+	listVersions = cliCtx.Bool("versions")
+	if listVersions && listFormat != listFormatJSONL {
+		console.Fatalln(fmt.Errorf("--versions requires --format %s", listFormatJSONL))
+	}
+	if since := cliCtx.String("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			console.Fatalln(fmt.Errorf("invalid --since %s: %w", since, err))
+		}
+		listSince = t
+		logMsg(fmt.Sprintf("Listing objects changed since %s", listSince.Format(time.RFC3339)))
+	}
+	if until := cliCtx.String("until"); until != "" {
+		t, err := parseSince(until)
+		if err != nil {
+			console.Fatalln(fmt.Errorf("invalid --until %s: %w", until, err))
+		}
+		listUntil = t
+	}
+	listPruneUnchanged = cliCtx.Bool("prune-unchanged")
+	if err := parseFilterFlags(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	if err := parseSymlinkFlag(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	if (symlinkPolicy == symlinkFollow || symlinkPolicy == symlinkLink) && listFormat != listFormatJSONL {
+		console.Fatalln(fmt.Errorf("--symlinks %s requires --format %s", symlinkPolicy, listFormatJSONL))
+	}
+	listShards = cliCtx.Int("shards")
+	listShardBy = cliCtx.String("shard-by")
+	if listShards <= 0 {
+		console.Fatalln(fmt.Errorf("--shards must be greater than 0"))
+	}
+	switch listShardBy {
+	case shardByHash:
+	case shardBySize:
+		if listFormat != listFormatJSONL {
+			console.Fatalln(fmt.Errorf("--shard-by %s requires --format %s", shardBySize, listFormatJSONL))
+		}
+	default:
+		console.Fatalln(fmt.Errorf("--shard-by must be one of %s or %s", shardByHash, shardBySize))
+	}
+	listMode = cliCtx.String("mode")
+	switch listMode {
+	case listModeWalk:
+	case listModeQuery:
+		if inputPrefixFile != "" || listResume {
+			console.Fatalln(fmt.Errorf("--prefixes-file and --resume are not supported with --mode %s", listModeQuery))
+		}
+		queryURL = cliCtx.String("query-url")
+		queryHostHeader = cliCtx.String("query-host-header")
+		queryNamespaceName = cliCtx.String("query-namespace")
+		queryPageSize = cliCtx.Int("query-page-size")
+	default:
+		console.Fatalln(fmt.Errorf("--mode must be one of %s or %s", listModeWalk, listModeQuery))
+	}
+	if planFilePath != "" {
+		if inputPrefixFile != "" {
+			console.Fatalln(fmt.Errorf("--prefixes-file is not supported with --plan-file"))
+		}
+		return forEachPlannedNamespace(func(plannedNamespace) error {
+			return listNamespace(ctx)
+		})
+	}
+	return listNamespace(ctx)
+}
+
+// listNamespace lists the namespace at namespaceURL, or the prefixes of
+// it in --prefixes-file.
+func listNamespace(ctx context.Context) error {
+	if listMode == listModeQuery {
+		hcp.URL = namespaceURL
+		return hcp.queryObjectList(ctx)
+	}
 	var (
 		prefixes []string
 		err      error
@@ -180,13 +423,17 @@ func listAction(cliCtx *cli.Context) error {
 			console.Fatalln(fmt.Errorf("error reading %s: %v ", inputPrefixFile, err))
 		}
 	}
+	var incomplete int
 	for _, prefix := range prefixes {
 		hcp.URL = fmt.Sprintf("%s/%s", namespaceURL, prefix)
 		logMsg(fmt.Sprintf("Downloading namespace listing to disk for :%s", prefix))
 		if err := hcp.downloadObjectList(ctx, prefix); err != nil {
-			logDMsg("exiting from listing", err)
-			return err
+			console.Errorln(err)
+			incomplete++
 		}
 	}
+	if incomplete > 0 {
+		return fmt.Errorf("listing incomplete for %d of %d prefixes", incomplete, len(prefixes))
+	}
 	return nil
 }
@@ -3,18 +3,21 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	humanize "github.com/dustin/go-humanize"
 	"github.com/minio/minio/pkg/console"
 )
 
@@ -26,40 +29,125 @@ type Directory struct {
 	Path                   string  `xml:"path,attr,omitempty"`
 	UTF8Path               string  `xml:"utf8Path,attr,omitempty"`
 	ParentDir              string  `xml:"parentDir,attr,omitempty"`
-	utf8ParentDir          string  `xml:"utf8ParentDir,attr,omitempty"`
-	dirDeleted             bool    `xml:"dirDeleted,attr"`
-	showDeleted            bool    `xml:"showDeleted,attr"`
-	namespaceName          string  `xml:"namespaceName,attr,omitempty"`
-	utf8NamespaceName      string  `xml:"utf8NamespaceName,attr,omitempty"`
+	UTF8ParentDir          string  `xml:"utf8ParentDir,attr,omitempty"`
+	DirDeleted             bool    `xml:"dirDeleted,attr"`
+	ShowDeleted            bool    `xml:"showDeleted,attr"`
+	NamespaceName          string  `xml:"namespaceName,attr,omitempty"`
+	UTF8NamespaceName      string  `xml:"utf8NamespaceName,attr,omitempty"`
 	Entries                []Entry `xml:"entry"`
-	changeTimeMilliseconds int64   `xml:""changeTimeMilliseconds,omitempty"`
-	changeTimeString       string  `xml:"changeTimeString,omitempty"`
+	ChangeTimeMilliseconds string  `xml:"changeTimeMilliseconds,attr,omitempty"`
+	ChangeTimeString       string  `xml:"changeTimeString,attr,omitempty"`
 }
 
 // Entry represents a object/sub dir/symlink
 type Entry struct {
-	XMLName                   xml.Name `xml:"entry"`
-	URLName                   string   `xml:"urlName,attr"`
-	Utf8Name                  string   `xml:"utf8Name,attr"`
-	EntryType                 string   `xml:"type,attr"`
-	Size                      int64    `xml:"size,attr,omitempty"`
-	HashScheme                string   `xml:"hashScheme,attr,omitempty"`
-	Hash                      string   `xml:"hash,attr,omitempty"`
-	retentionString           string   `xml:"retentionString,attr,omitempty"`
-	retentionClass            string   `xml:"retentionClass,attr,omitempty"`
-	ingestTimeString          string   `xml:"ingestTimeString,attr,omitempty"`
-	hold                      bool     `xml:"hold,attr"`
-	shred                     bool     `xml:"shred,attr"`
-	dpl                       string   `xml:"dpl,attr,omitempty"`
-	customMetadata            bool     `xml:"customMetadata,attr"`
-	customMetadataAnnotations string   `xml:"customMetadataAnnotations,attr,omitempty"`
-	version                   string   `xml:"version,attr,omitempty"`
-	replicated                bool     `xml:"replicated,attr"`
-	changeTimeString          string   `xml:"changeTimeString,attr,omitempty"`
-	domain                    string   `xml:"domain,attr,omitempty"`
-	hasACL                    bool     `xml:"hasAcl,attr"`
-	state                     string   `xml:"state,attr,omitempty"`
-	objectPath                string   // relative url holding path of this object
+	XMLName                   xml.Name `xml:"entry" json:"-"`
+	ObjectPath                string   `xml:"-" json:"objectPath"` // relative url holding path of this object
+	URLName                   string   `xml:"urlName,attr" json:"urlName"`
+	Utf8Name                  string   `xml:"utf8Name,attr" json:"utf8Name"`
+	EntryType                 string   `xml:"type,attr" json:"type"`
+	Size                      int64    `xml:"size,attr,omitempty" json:"size"`
+	HashScheme                string   `xml:"hashScheme,attr,omitempty" json:"hashScheme,omitempty"`
+	Hash                      string   `xml:"hash,attr,omitempty" json:"hash,omitempty"`
+	Retention                 int64    `xml:"retention,attr,omitempty" json:"retention,omitempty"`
+	RetentionString           string   `xml:"retentionString,attr,omitempty" json:"retentionString,omitempty"`
+	RetentionClass            string   `xml:"retentionClass,attr,omitempty" json:"retentionClass,omitempty"`
+	IngestTime                int64    `xml:"ingestTime,attr,omitempty" json:"ingestTime,omitempty"`
+	IngestTimeString          string   `xml:"ingestTimeString,attr,omitempty" json:"ingestTimeString,omitempty"`
+	Hold                      bool     `xml:"hold,attr" json:"hold,omitempty"`
+	Shred                     bool     `xml:"shred,attr" json:"shred,omitempty"`
+	DPL                       string   `xml:"dpl,attr,omitempty" json:"dpl,omitempty"`
+	Index                     bool     `xml:"index,attr" json:"index,omitempty"`
+	CustomMetadata            bool     `xml:"customMetadata,attr" json:"customMetadata,omitempty"`
+	CustomMetadataAnnotations string   `xml:"customMetadataAnnotations,attr,omitempty" json:"customMetadataAnnotations,omitempty"`
+	Version                   string   `xml:"version,attr,omitempty" json:"version,omitempty"`
+	Replicated                bool     `xml:"replicated,attr" json:"replicated,omitempty"`
+	ChangeTimeMilliseconds    int64    `xml:"-" json:"changeTimeMilliseconds,omitempty"`
+	RawChangeTime             string   `xml:"changeTimeMilliseconds,attr,omitempty" json:"-"` // as sent by HCP, with a fractional part
+	ChangeTimeString          string   `xml:"changeTimeString,attr,omitempty" json:"changeTimeString,omitempty"`
+	Owner                     string   `xml:"owner,attr,omitempty" json:"owner,omitempty"`
+	Domain                    string   `xml:"domain,attr,omitempty" json:"domain,omitempty"`
+	HasACL                    bool     `xml:"hasAcl,attr" json:"hasAcl,omitempty"`
+	State                     string   `xml:"state,attr,omitempty" json:"state,omitempty"`
+	Versions                  []Entry  `xml:"-" json:"versions,omitempty"`      // all versions of this object, oldest first
+	SymlinkTarget             string   `xml:"-" json:"symlinkTarget,omitempty"` // target path of a symbolic link
+}
+
+// parseChangeTime returns the milliseconds of a changeTimeMilliseconds
+// attribute of HCP, which has a fractional part like "1330366855000.00".
+func parseChangeTime(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	ms, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid changeTimeMilliseconds %s", s)
+	}
+	return int64(ms), nil
+}
+
+// decodeChangeTime sets the change time of an entry decoded from HCP XML.
+func (e *Entry) decodeChangeTime() (err error) {
+	if e.ChangeTimeMilliseconds, err = parseChangeTime(e.RawChangeTime); err != nil {
+		return fmt.Errorf("%w of %s", err, e.URLName)
+	}
+	return nil
+}
+
+// changeTime returns the time the entry was last changed on HCP, or
+// the time it was ingested if HCP did not report a change time.
+func (e Entry) changeTime() time.Time {
+	if e.ChangeTimeMilliseconds > 0 {
+		return time.Unix(0, e.ChangeTimeMilliseconds*int64(time.Millisecond))
+	}
+	return time.Unix(e.IngestTime, 0)
+}
+
+// totalSize returns the size of the object, or of all its versions if
+// they were listed.
+func (e Entry) totalSize() int64 {
+	if len(e.Versions) == 0 {
+		return e.Size
+	}
+	var size int64
+	for _, v := range e.Versions {
+		size += v.Size
+	}
+	return size
+}
+
+const (
+	listFormatText  = "text"
+	listFormatJSONL = "jsonl"
+)
+
+// formatEntry returns the line recorded for entry in the object listing.
+func formatEntry(entry Entry) (string, error) {
+	if listFormat == listFormatJSONL {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	return entry.ObjectPath, nil
+}
+
+// parseListingLine parses a line of an object listing in either format.
+// Lines of a text listing only carry the object path, so the remaining
+// fields of the returned entry are left empty.
+func parseListingLine(line string) (entry Entry, err error) {
+	if strings.HasPrefix(line, "{") {
+		if err = json.Unmarshal([]byte(line), &entry); err != nil {
+			return entry, err
+		}
+		if entry.ObjectPath == "" {
+			return entry, fmt.Errorf("listing entry has no objectPath: %s", line)
+		}
+		return entry, nil
+	}
+	entry.ObjectPath = line
+	return entry, nil
 }
 
 // Job for worker
@@ -67,165 +155,641 @@ type listWorkerJob struct {
 	Root string
 }
 
+// listEvent is sent by list workers to the listing writer. It carries
+// either an entry found in a directory, the path of a directory whose
+// entries have all been sent or the path of a directory that could not
+// be listed.
+type listEvent struct {
+	entry     Entry
+	dirDone   string
+	dirFailed string
+	err       error
+}
+
+// listQueueLimit is the number of directories a listQueue holds in
+// memory. Directories found while it is full are spilled to a file in
+// --data-dir and read back once the queue drains.
+const listQueueLimit = 100000
+
+const listQueueFile = "list_queue"
+
+// listQueueSpillPath returns the path of the file the list queue of a
+// listing of prefix spills to.
+func listQueueSpillPath(prefix string) string {
+	if prefix == "" {
+		return path.Join(dirPath, listQueueFile+".spill")
+	}
+	return path.Join(dirPath, fmt.Sprintf("%s_%s.spill", listQueueFile, fileNamePrefix(prefix)))
+}
+
+// listQueue is the queue of directories waiting to be listed. It is
+// shared by all list workers, which push every sub directory they find
+// and pop the next one to list. The walk is complete when nothing is
+// pending and no worker is still listing a directory.
+type listQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []listWorkerJob
+	active  int
+	closed  bool
+
+	// limit bounds pending. Directories pushed while pending is full are
+	// appended to the spill file, spilled counts those not read back yet
+	// and spillOff is the offset of the next one to read.
+	limit     int
+	spillPath string
+	spill     *os.File
+	spillW    *bufio.Writer
+	spillOff  int64
+	spilled   int
+	spillErr  error
+
+	// known holds directories already recorded by a previous run of a
+	// resumed listing, which must not be queued again when found. It is
+	// only written before the workers start.
+	known map[string]struct{}
+}
+
+// newListQueue returns a queue holding at most limit directories in
+// memory, spilling the others to spillPath.
+func newListQueue(limit int, spillPath string) *listQueue {
+	q := &listQueue{limit: limit, spillPath: spillPath}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a directory to the queue.
+func (q *listQueue) push(j listWorkerJob) {
+	q.mu.Lock()
+	if q.closed {
+		// nothing is popped any more
+		q.mu.Unlock()
+		return
+	}
+	if len(q.pending) < q.limit {
+		q.pending = append(q.pending, j)
+	} else if err := q.spillJob(j); err != nil {
+		// the directory is still pending in the list checkpoint, so it is
+		// listed by the next run with --resume
+		q.spillErr = fmt.Errorf("unable to spill the list queue to %s: %w", q.spillPath, err)
+		q.closed = true
+		q.mu.Unlock()
+		q.cond.Broadcast()
+		return
+	}
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// spillJob appends j to the spill file, creating it on first use.
+func (q *listQueue) spillJob(j listWorkerJob) error {
+	if q.spill == nil {
+		f, err := os.OpenFile(q.spillPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		q.spill = f
+		q.spillW = bufio.NewWriter(f)
+	}
+	if _, err := q.spillW.WriteString(j.Root + "\n"); err != nil {
+		return err
+	}
+	q.spilled++
+	return nil
+}
+
+// unspill reads spilled directories back into pending, up to the limit,
+// and empties the spill file once all of them have been read.
+func (q *listQueue) unspill() error {
+	if err := q.spillW.Flush(); err != nil {
+		return err
+	}
+	r := bufio.NewReader(io.NewSectionReader(q.spill, q.spillOff, math.MaxInt64-q.spillOff))
+	for q.spilled > 0 && len(q.pending) < q.limit {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		q.spillOff += int64(len(line))
+		q.spilled--
+		q.pending = append(q.pending, listWorkerJob{Root: strings.TrimSuffix(line, "\n")})
+	}
+	if q.spilled == 0 {
+		q.spillOff = 0
+		return q.spill.Truncate(0)
+	}
+	return nil
+}
+
+// pop returns the next directory to list, blocking while other workers
+// may still add more. It returns false once the walk is complete or the
+// queue is closed. Every successful pop must be followed by done.
+func (q *listQueue) pop() (listWorkerJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) == 0 && q.spilled == 0 && q.active > 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.pending) == 0 && q.spilled > 0 && !q.closed {
+		if err := q.unspill(); err != nil {
+			q.spillErr = fmt.Errorf("unable to read the list queue back from %s: %w", q.spillPath, err)
+			q.closed = true
+		}
+	}
+	if len(q.pending) == 0 || q.closed {
+		q.cond.Broadcast()
+		return listWorkerJob{}, false
+	}
+	// Take the most recently found directory so that the walk is depth
+	// first and the pending list stays small on wide namespaces.
+	j := q.pending[len(q.pending)-1]
+	q.pending = q.pending[:len(q.pending)-1]
+	q.active++
+	return j, true
+}
+
+// done marks a popped directory as listed.
+func (q *listQueue) done() {
+	q.mu.Lock()
+	q.active--
+	if q.active == 0 && len(q.pending) == 0 && q.spilled == 0 {
+		q.cond.Broadcast()
+	}
+	q.mu.Unlock()
+}
+
+// close wakes up all workers and makes pop return false.
+func (q *listQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// removeSpill removes the spill file, if any. It must only be called once
+// all workers have returned.
+func (q *listQueue) removeSpill() {
+	if q.spill == nil {
+		return
+	}
+	q.spill.Close()
+	os.Remove(q.spillPath)
+	q.spill, q.spillW, q.spillOff, q.spilled = nil, nil, 0, 0
+}
+
 func getFileName(fname, prefix string) string {
 	if prefix == "" {
 		return fmt.Sprintf("%s%s", fname, time.Now().Format(".01-02-2006-15-04-05"))
 	}
-	return fmt.Sprintf("%s_%s%s", fname, prefix, time.Now().Format(".01-02-2006-15-04-05"))
+	return fmt.Sprintf("%s_%s%s", fname, fileNamePrefix(prefix), time.Now().Format(".01-02-2006-15-04-05"))
+}
+
+// fileNamePrefix returns prefix in a form that can be used as part of a
+// file name in --data-dir.
+func fileNamePrefix(prefix string) string {
+	return strings.ReplaceAll(strings.Trim(prefix, "/"), "/", "_")
+}
+
+// listingWriter writes the object listing of a listing run.
+type listingWriter struct {
+	*bufio.Writer
+	f    *os.File
+	name string
+	// listed holds objects written by the previous run of a resumed
+	// listing which must not be written again.
+	listed map[string]struct{}
+	stats  *listingStats
+	prefix string
+	// symlinks is the report of symbolic links for --symlinks report,
+	// created when the first link is found.
+	symlinks *os.File
+}
+
+// newListingWriter creates a new object listing for prefix in --data-dir
+// of the objects under root.
+func newListingWriter(root, prefix string) (*listingWriter, error) {
+	name := objListFile
+	if listFormat == listFormatJSONL {
+		name = objListJSONFile
+	}
+	name = getFileName(name, prefix)
+	f, err := os.OpenFile(path.Join(dirPath, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_SYNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &listingWriter{
+		Writer: bufio.NewWriter(f),
+		f:      f,
+		name:   name,
+		stats:  newListingStats(root),
+		prefix: prefix,
+	}, nil
+}
+
+// resumeListingWriter reopens the object listing of the checkpoint cp
+// of prefix for appending. The objects already in the listing are added
+// to the inventory of the run.
+func resumeListingWriter(cp *listCheckpoint, root, prefix string) (*listingWriter, error) {
+	stats := newListingStats(root)
+	listed, err := loadListedObjects(path.Join(dirPath, cp.listFile), cp.pending, stats)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path.Join(dirPath, cp.listFile), os.O_WRONLY|os.O_APPEND|os.O_SYNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &listingWriter{
+		Writer: bufio.NewWriter(f),
+		f:      f,
+		name:   cp.listFile,
+		listed: listed,
+		stats:  stats,
+		prefix: prefix,
+	}, nil
+}
+
+// writeEntry records an object in the listing. Symbolic links are
+// recorded in the listing or in the symbolic link report as set by
+// --symlinks, other entries are only counted as dropped.
+func (lw *listingWriter) writeEntry(entry Entry) error {
+	if entry.EntryType == "symlink" && symlinkPolicy == symlinkReport {
+		lw.stats.addDropped(entry)
+		return lw.writeSymlink(entry)
+	}
+	if entry.EntryType != "object" && (entry.EntryType != "symlink" || symlinkPolicy == symlinkSkip) {
+		logDMsg("Dropped Entry>>>"+entry.ObjectPath, nil)
+		lw.stats.addDropped(entry)
+		return nil
+	}
+	if _, ok := lw.listed[entry.ObjectPath]; ok {
+		return nil
+	}
+	line, err := formatEntry(entry)
+	if err != nil {
+		return err
+	}
+	if _, err = lw.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	lw.stats.addObject(entry)
+	return nil
+}
+
+// writeSymlink records a symbolic link and its target in the symbolic
+// link report.
+func (lw *listingWriter) writeSymlink(entry Entry) error {
+	if lw.symlinks == nil {
+		name := getFileName(symlinkReportFile, lw.prefix)
+		f, err := os.OpenFile(path.Join(dirPath, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		logMsg(fmt.Sprintf("Recording symbolic links in %s", name))
+		lw.symlinks = f
+	}
+	_, err := lw.symlinks.WriteString(entry.ObjectPath + " -> " + entry.SymlinkTarget + "\n")
+	return err
 }
+
+// writeSummary writes the inventory of the listing to --data-dir.
+func (lw *listingWriter) writeSummary(prefix string, complete bool) error {
+	lw.stats.Listing = lw.name
+	lw.stats.Complete = complete
+	name, err := lw.stats.write(prefix)
+	if err != nil {
+		return err
+	}
+	logMsg(fmt.Sprintf("Listed %s objects, %s, summary in %s", humanize.Comma(lw.stats.Objects),
+		humanize.IBytes(uint64(lw.stats.Bytes)), name))
+	return nil
+}
+
+// Close flushes and closes the object listing.
+func (lw *listingWriter) Close() error {
+	err := lw.Flush()
+	if cerr := lw.f.Close(); err == nil {
+		err = cerr
+	}
+	if lw.symlinks != nil {
+		if cerr := lw.symlinks.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 func (hcp *hcpBackend) downloadObjectList(ctx context.Context, prefix string) error {
-	f, err := os.OpenFile(path.Join(dirPath, getFileName(objListFile, prefix)), os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_SYNC, 0600)
+	u, err := url.Parse(hcp.URL)
 	if err != nil {
 		return err
 	}
-	datawriter := bufio.NewWriter(f)
-	workerCount := 1
+	root := path.Clean(u.Path)
 
-	jobs := make(chan listWorkerJob, workerCount)
-	entryCh := make(chan Entry, 1000)
-	readDone := make(chan bool)
-	wg := &sync.WaitGroup{}
-	// start N workers
+	var cp *listCheckpoint
+	if listResume {
+		cp, err = loadListCheckpoint(prefix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if cp != nil && cp.complete {
+		logMsg(fmt.Sprintf("Listing of %s is already complete in %s", root, cp.listFile))
+		if err = cp.Close(); err == nil && listShards > 1 {
+			err = shardListing(cp.listFile, prefix)
+		}
+		return err
+	}
+	var lw *listingWriter
+	if cp != nil {
+		if cp.format != listFormat {
+			logMsg(fmt.Sprintf("Resuming listing in %s format of %s", cp.format, cp.listFile))
+			listFormat = cp.format
+		}
+		lw, err = resumeListingWriter(cp, root, prefix)
+		if err != nil {
+			cp.Close()
+			return err
+		}
+		logMsg(fmt.Sprintf("Resuming listing of %d pending directories into %s", len(cp.pending), cp.listFile))
+	} else {
+		lw, err = newListingWriter(root, prefix)
+		if err != nil {
+			return err
+		}
+		cp, err = newListCheckpoint(prefix, lw.name, listFormat)
+		if err == nil {
+			err = cp.addPending(root)
+		}
+		if err != nil {
+			lw.Close()
+			return err
+		}
+	}
+	defer cp.Close()
+	defer lw.Close()
 
-	for i := 0; i < workerCount; i++ {
-		go hcp.List(ctx, jobs, entryCh, wg)
+	failFile := getFileName(listFailFile, prefix)
+	ff, err := os.OpenFile(path.Join(dirPath, failFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
 	}
+	defer ff.Close()
+	var failCnt int
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// One initial job
-	wg.Add(1)
+	q := newListQueue(listQueueLimit, listQueueSpillPath(prefix))
+	defer q.removeSpill()
+	q.known = cp.known
+	for dir := range cp.pending {
+		q.push(listWorkerJob{
+			Root: dir,
+		})
+	}
 	go func() {
-		jobs <- listWorkerJob{
-			Root: "",
-		}
+		<-ctx.Done()
+		q.close()
 	}()
 
-	// When all jobs finished, shutdown the system.
+	eventCh := make(chan listEvent, 1000)
+	wg := &sync.WaitGroup{}
+	// start N workers
+	for i := 0; i < listWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hcp.List(ctx, q, eventCh)
+		}()
+	}
+	// When all workers are finished, shutdown the system.
 	go func() {
 		wg.Wait()
-		readDone <- true
+		close(eventCh)
 	}()
-readloop:
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
 	for {
 		select {
-		case entry, ok := <-entryCh:
+		case <-ticker.C:
+			if err := cp.commit(lw.Writer); err != nil {
+				return err
+			}
+			continue
+		case ev, ok := <-eventCh:
 			if !ok {
-				break readloop
+				if err := cp.commit(lw.Writer); err != nil {
+					return err
+				}
+				var err error
+				switch {
+				case ctx.Err() != nil:
+					err = ctx.Err()
+				case q.spillErr != nil:
+					err = fmt.Errorf("listing of %s is incomplete, %w, rerun with --resume", root, q.spillErr)
+				case failCnt > 0:
+					err = fmt.Errorf("listing of %s is incomplete, %d directories failed, see %s and rerun with --resume", root, failCnt, failFile)
+				case len(cp.pending) > 0:
+					err = fmt.Errorf("listing of %s is incomplete, %d directories are still pending, rerun with --resume", root, len(cp.pending))
+				default:
+					err = cp.markComplete()
+				}
+				if serr := lw.writeSummary(prefix, cp.complete); err == nil {
+					err = serr
+				}
+				if err == nil && listShards > 1 {
+					err = shardListing(lw.name, prefix)
+				}
+				return err
 			}
-			if entry.EntryType != "object" {
-				logDMsg("received non object entry in channel>"+entry.objectPath, nil)
+			if ev.dirDone != "" {
+				cp.markDone(ev.dirDone)
 				continue
 			}
-			if _, err := datawriter.WriteString(entry.objectPath + "\n"); err != nil {
+			if ev.dirFailed != "" {
+				failCnt++
+				if _, err := ff.WriteString(ev.dirFailed + " : " + ev.err.Error() + "\n"); err != nil {
+					return err
+				}
+				continue
+			}
+			entry := ev.entry
+			if entry.EntryType == "directory" {
+				if err := cp.addPending(entry.ObjectPath); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := lw.writeEntry(entry); err != nil {
 				return err
 			}
-		case <-readDone:
-			log.Printf(`got stop`)
-			close(jobs)
-			close(entryCh)
 		}
 	}
-	datawriter.Flush()
-	f.Close()
-	return nil
 }
 
-func (hcp *hcpBackend) List(ctx context.Context, jobs chan listWorkerJob, entryCh chan Entry, wg *sync.WaitGroup) {
-	for j := range jobs {
-		u, err := url.Parse(hcp.URL)
-		if err != nil {
-			logDMsg(fmt.Sprintf("Couldn't create a request with hcp.URL %s", hcp.URL), err)
-			continue
+// List is run by each list worker. It lists directories from the queue
+// until the walk is complete, sending objects found to eventCh and
+// queueing sub directories for the next available worker.
+func (hcp *hcpBackend) List(ctx context.Context, q *listQueue, eventCh chan<- listEvent) {
+	for {
+		j, ok := q.pop()
+		if !ok {
+			return
 		}
-		if j.Root != "" {
-			u.Path = j.Root
+		ev := listEvent{dirDone: j.Root}
+		if err := hcp.listDirectory(ctx, j, q, eventCh); err != nil {
+			logMsg(fmt.Sprintf("Couldn't list directory %s: %s", j.Root, err))
+			ev = listEvent{dirFailed: j.Root, err: err}
 		}
-		logDMsg(fmt.Sprintf(`Directory: %#v`, u.Path), nil)
-		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
-		if err != nil {
-			logDMsg(fmt.Sprintf("Couldn't create a request with hcp.URL %s", hcp.URL), err)
+		select {
+		case eventCh <- ev:
+		case <-ctx.Done():
+		}
+		// Done one job, let the queue know.
+		q.done()
+	}
+}
+
+// listDirectory lists the directory of j. Entries found are sent to
+// eventCh, sub directories are sent before they are queued so that the
+// writer records them as pending before they can be listed.
+func (hcp *hcpBackend) listDirectory(ctx context.Context, j listWorkerJob, q *listQueue, eventCh chan<- listEvent) error {
+	var dir *Directory
+	err := withRetry(ctx, listRetries, "listing of "+j.Root, func() (err error) {
+		dir, err = hcp.readDirectory(ctx, j.Root)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range dir.Entries {
+		entry.ObjectPath = path.Join(dir.Path, entry.URLName)
+		logDMsg("read entry>"+entry.URLName+" at path>"+entry.ObjectPath, nil)
+
+		if entry.EntryType == "object" && !listUntil.IsZero() && entry.changeTime().After(listUntil) {
 			continue
 		}
-		req.Header.Set("Authorization", authToken)
-		req.Host = hostHeader
-		resp, err := hcp.Client().Do(req)
-		// logDMsg("REQUEST:>"+req.URL.String(), nil)
-		// if resp != nil {
-		// 	logDMsg("Resp statuscode =>"+strconv.Itoa(resp.StatusCode), nil)
-		// }
-		if debugFlag {
-			console.Println(trace(req, resp))
+		if !listSince.IsZero() && entry.changeTime().Before(listSince) {
+			switch {
+			case entry.EntryType == "object":
+				continue
+			case entry.EntryType == "directory" && listPruneUnchanged && entry.ChangeTimeMilliseconds > 0:
+				logDMsg("pruned unchanged directory>"+entry.ObjectPath, nil)
+				continue
+			}
 		}
-		if err != nil {
-			logDMsg(fmt.Sprintf("Couldn't list namespace directory contents with namespace URL %s", hcp.URL), err)
+		switch {
+		case entry.EntryType == "object" && !filter.matchEntry(entry):
+			continue
+		case entry.EntryType == "symlink" && !filter.matchPath(entry.ObjectPath):
+			continue
+		case entry.EntryType == "directory" && filter.pruneDir(entry.ObjectPath):
+			logDMsg("pruned filtered directory>"+entry.ObjectPath, nil)
 			continue
 		}
-		decoder := xml.NewDecoder(resp.Body)
-		for {
-			// Read tokens from the XML document in a stream.
-			t, err := decoder.Token()
-			// If we are at the end of the file, we are done
-			if err == io.EOF {
-				//	logDMsg("breaking Dir XML parsing because EOF", nil)
-				resp.Body.Close()
-				io.Copy(ioutil.Discard, resp.Body)
-				break
-			} else if err != nil {
-				log.Fatalf("Error decoding token: %s", err)
-			} else if t == nil {
-				resp.Body.Close()
-				io.Copy(ioutil.Discard, resp.Body)
-				break
+		if entry.EntryType == "symlink" && symlinkPolicy != symlinkSkip {
+			err := withRetry(ctx, listRetries, "reading symbolic link "+entry.ObjectPath, func() (err error) {
+				entry.SymlinkTarget, err = hcp.ReadSymlink(ctx, entry.ObjectPath)
+				return err
+			})
+			if err != nil {
+				return err
 			}
-
-			switch se := t.(type) {
-			case xml.StartElement:
-				switch se.Name.Local {
-				// Found a dir, so we process it
-				case "directory":
-					var dir Directory
-
-					// We decode the element into our data model...
-					if err = decoder.DecodeElement(&dir, &se); err != nil {
-						log.Fatalf("Error decoding item: %s", err)
-					}
-					for _, entry := range dir.Entries {
-						entry.objectPath = path.Join(dir.Path, entry.URLName)
-						logDMsg("read entry>"+entry.URLName+" at path>"+entry.objectPath, nil)
-
-						switch entry.EntryType {
-						case "object":
-							entryCh <- entry
-						case "directory":
-							// Send directory to be processed by the worker
-							nj := listWorkerJob{
-								Root: entry.objectPath,
-							}
-							// logDMsg("sent new dir job: "+nj.Root, nil)
-
-							// One more job, adds to wg
-							wg.Add(1)
-
-							// Do not block when sending jobs
-							go func() {
-								jobs <- nj
-							}()
-						default:
-							logDMsg("Dropped Entry>>>"+entry.objectPath, nil)
-						}
-					}
-					// // And use it for whatever we want to
-					// log.Printf("'%s' : %v", dir.namespaceName, dir.Entries)
-
-				default:
+		}
+		if entry.EntryType == "object" && listVersions {
+			err := withRetry(ctx, listRetries, "version listing of "+entry.ObjectPath, func() (err error) {
+				entry.Versions, err = hcp.ListVersions(ctx, entry.ObjectPath)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		switch entry.EntryType {
+		case "object", "directory":
+			if entry.EntryType == "directory" {
+				if _, ok := q.known[entry.ObjectPath]; ok {
+					continue
 				}
 			}
 			select {
+			case eventCh <- listEvent{entry: entry}:
 			case <-ctx.Done():
-				return
-			default:
+				return ctx.Err()
+			}
+			if entry.EntryType == "directory" {
+				// Send directory to be processed by the next free worker
+				q.push(listWorkerJob{
+					Root: entry.ObjectPath,
+				})
+			}
+		default:
+			// Symbolic links and other entries are left to the writer
+			// to record as set by --symlinks or count as dropped.
+			select {
+			case eventCh <- listEvent{entry: entry}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// readDirectory returns the listing of the HCP directory at root. The
+// whole listing is decoded before it is returned, so a request that
+// fails half way can be retried without sending any entry twice.
+func (hcp *hcpBackend) readDirectory(ctx context.Context, root string) (*Directory, error) {
+	u, err := url.Parse(hcp.URL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = root
+	logDMsg(fmt.Sprintf(`Directory: %#v`, u.Path), nil)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", authToken)
+	req.Host = hostHeader
+	resp, err := hcp.Client().Do(req)
+	if debugFlag {
+		console.Println(trace(req, resp))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closeResponse(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHCPError(resp)
+	}
+	decoder := xml.NewDecoder(resp.Body)
+	for {
+		// Read tokens from the XML document in a stream.
+		t, err := decoder.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no directory listing in response")
+		} else if err != nil {
+			return nil, fmt.Errorf("error decoding token: %w", err)
+		}
+
+		se, ok := t.(xml.StartElement)
+		if !ok || se.Name.Local != "directory" {
+			continue
+		}
+		// Found a dir, so we decode the element into our data model...
+		var dir Directory
+		if err = decoder.DecodeElement(&dir, &se); err != nil {
+			return nil, fmt.Errorf("error decoding directory: %w", err)
+		}
+		for i := range dir.Entries {
+			if err = dir.Entries[i].decodeChangeTime(); err != nil {
+				return nil, fmt.Errorf("error decoding directory: %w", err)
 			}
 		}
-		// Done one job, let wg know.
-		wg.Done()
+		return &dir, nil
 	}
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// popAsync pops q in a goroutine and returns the result on a channel.
+func popAsync(q *listQueue) <-chan bool {
+	ch := make(chan bool, 1)
+	go func() {
+		_, ok := q.pop()
+		ch <- ok
+	}()
+	return ch
+}
+
+func TestListQueueWalk(t *testing.T) {
+	// each directory down to depth 3 has 3 sub directories
+	const depth, fanout = 3, 3
+	testCases := []struct {
+		limit   int
+		spilled bool // directories are spilled to disk during the walk
+	}{
+		{listQueueLimit, false},
+		{4, true},
+		{1, true},
+	}
+	for i, testCase := range testCases {
+		spillPath := filepath.Join(t.TempDir(), "queue.spill")
+		q := newListQueue(testCase.limit, spillPath)
+		q.push(listWorkerJob{Root: "/rest"})
+		var (
+			mu      sync.Mutex
+			listed  = make(map[string]int)
+			wg      sync.WaitGroup
+			overrun bool
+		)
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					j, ok := q.pop()
+					if !ok {
+						return
+					}
+					mu.Lock()
+					listed[j.Root]++
+					mu.Unlock()
+					if strings.Count(j.Root, "/")-1 < depth {
+						for i := 0; i < fanout; i++ {
+							q.push(listWorkerJob{Root: fmt.Sprintf("%s/d%d", j.Root, i)})
+						}
+					}
+					q.mu.Lock()
+					if len(q.pending) > q.limit {
+						overrun = true
+					}
+					q.mu.Unlock()
+					q.done()
+				}
+			}()
+		}
+		finished := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Test %d: workers did not return once the walk was complete", i+1)
+		}
+		if want := 1 + 3 + 9 + 27; len(listed) != want {
+			t.Errorf("Test %d: listed %d directories, want %d", i+1, len(listed), want)
+		}
+		for dir, n := range listed {
+			if n != 1 {
+				t.Errorf("Test %d: listed %s %d times", i+1, dir, n)
+			}
+		}
+		if overrun {
+			t.Errorf("Test %d: more than %d directories held in memory", i+1, testCase.limit)
+		}
+		if fi, err := os.Stat(spillPath); (err == nil) != testCase.spilled || (err == nil && fi.Size() != 0) {
+			t.Errorf("Test %d: spill file is %v, %v once the walk is complete", i+1, fi, err)
+		}
+		q.removeSpill()
+		if _, err := os.Stat(spillPath); !os.IsNotExist(err) {
+			t.Errorf("Test %d: spill file not removed: %v", i+1, err)
+		}
+	}
+}
+
+func TestListQueueClose(t *testing.T) {
+	testCases := []struct {
+		pending int // directories pending when the queue is closed
+		active  int // directories being listed when the queue is closed
+		limit   int
+	}{
+		{0, 0, listQueueLimit},
+		{0, 1, listQueueLimit},
+		{2, 0, listQueueLimit},
+		{2, 1, listQueueLimit},
+		{2, 1, 1},
+	}
+	for i, testCase := range testCases {
+		q := newListQueue(testCase.limit, filepath.Join(t.TempDir(), "queue.spill"))
+		for j := 0; j < testCase.active+testCase.pending; j++ {
+			q.push(listWorkerJob{Root: fmt.Sprint(j)})
+		}
+		for j := 0; j < testCase.active; j++ {
+			if _, ok := q.pop(); !ok {
+				t.Fatalf("Test %d: pop failed before close", i+1)
+			}
+		}
+		// a worker waiting for more directories is woken up by close
+		var waiting <-chan bool
+		if testCase.pending == 0 && testCase.active > 0 {
+			waiting = popAsync(q)
+		}
+		q.close()
+		if waiting != nil {
+			select {
+			case ok := <-waiting:
+				if ok {
+					t.Errorf("Test %d: waiting pop returned a directory after close", i+1)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("Test %d: waiting pop not woken up by close", i+1)
+			}
+		}
+		// nothing is popped once closed, even if directories are pending
+		select {
+		case ok := <-popAsync(q):
+			if ok {
+				t.Errorf("Test %d: pop returned a directory after close", i+1)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Test %d: pop blocked after close", i+1)
+		}
+		for j := 0; j < testCase.active; j++ {
+			q.done()
+		}
+		q.removeSpill()
+	}
+}
+
+// hcpDirectoryListing is a directory listing as sent by HCP, with the
+// fractional changeTimeMilliseconds it reports.
+const hcpDirectoryListing = `<?xml version="1.0" encoding="UTF-8"?>
+<directory xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"
+    xsi:noNamespaceSchemaLocation="/static/xsd/ns-directory.xsd"
+    path="/rest/images"
+    utf8Path="/rest/images"
+    parentDir="/rest"
+    utf8ParentDir="/rest"
+    dirDeleted="false"
+    showDeleted="false"
+    namespaceName="finance"
+    utf8NamespaceName="finance"
+    changeTimeMilliseconds="1330366855000.00"
+    changeTimeString="2012-02-27T13:20:55-0500">
+  <entry urlName="earth.jpg"
+      utf8Name="earth.jpg"
+      type="object"
+      size="33000"
+      hashScheme="SHA-256"
+      hash="3B2A0AA5F1B6A1E8A47D67C5B6E06C0B1A0B4B02B2E0E4B7C8E1A5E9E2D5B2A1"
+      retention="0"
+      retentionString="Deletion Allowed"
+      retentionClass=""
+      ingestTime="1330366855"
+      ingestTimeString="2/27/2012 1:20PM"
+      hold="false"
+      shred="false"
+      dpl="2"
+      index="true"
+      customMetadata="false"
+      customMetadataAnnotations=""
+      version="84233681234881"
+      replicated="false"
+      changeTimeMilliseconds="1330366855213.00"
+      changeTimeString="2012-02-27T13:20:55-0500"
+      owner="USER,admin"
+      domain=""
+      hasAcl="false"
+      state="created"/>
+  <entry urlName="sunsets"
+      utf8Name="sunsets"
+      type="directory"
+      changeTimeMilliseconds="1330366798123.00"
+      changeTimeString="2012-02-27T13:19:58-0500"
+      state="created"/>
+</directory>
+`
+
+func TestReadDirectory(t *testing.T) {
+	testCases := []struct {
+		listing string
+		entries []Entry
+		success bool
+	}{
+		{hcpDirectoryListing, []Entry{
+			{URLName: "earth.jpg", EntryType: "object", Size: 33000, IngestTime: 1330366855, Version: "84233681234881", ChangeTimeMilliseconds: 1330366855213},
+			{URLName: "sunsets", EntryType: "directory", ChangeTimeMilliseconds: 1330366798123},
+		}, true},
+		// whole milliseconds and no change time
+		{`<directory path="/rest/a"><entry urlName="b" type="object" changeTimeMilliseconds="1600000000000"/><entry urlName="c" type="symlink"/></directory>`, []Entry{
+			{URLName: "b", EntryType: "object", ChangeTimeMilliseconds: 1600000000000},
+			{URLName: "c", EntryType: "symlink"},
+		}, true},
+		{`<directory path="/rest/a"><entry urlName="b" type="object" changeTimeMilliseconds="yesterday"/></directory>`, nil, false},
+		{`<error/>`, nil, false},
+	}
+	for i, testCase := range testCases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml")
+			io.WriteString(w, testCase.listing)
+		}))
+		h := &hcpBackend{URL: srv.URL + "/rest", client: srv.Client()}
+		dir, err := h.readDirectory(context.Background(), "/rest/images")
+		srv.Close()
+		if err != nil && testCase.success {
+			t.Errorf("Test %d: unexpected error %v", i+1, err)
+			continue
+		}
+		if err == nil && !testCase.success {
+			t.Errorf("Test %d: expected an error", i+1)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if len(dir.Entries) != len(testCase.entries) {
+			t.Errorf("Test %d: %d entries, want %d", i+1, len(dir.Entries), len(testCase.entries))
+			continue
+		}
+		for j, want := range testCase.entries {
+			got := dir.Entries[j]
+			if got.URLName != want.URLName || got.EntryType != want.EntryType || got.Size != want.Size ||
+				got.IngestTime != want.IngestTime || got.Version != want.Version || got.ChangeTimeMilliseconds != want.ChangeTimeMilliseconds {
+				t.Errorf("Test %d: entry %d is %+v, want %+v", i+1, j+1, got, want)
+			}
+		}
+	}
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path"
 	"runtime"
@@ -11,17 +14,34 @@ import (
 	"sync/atomic"
 
 	miniogo "github.com/minio/minio-go/v7"
+	"github.com/minio/minio/pkg/console"
 )
 
-var dryRun bool
+var (
+	dryRun          bool
+	migrateVersions bool
+	// migrateRetries is the number of times an object is migrated again
+	// after a transient failure, each time from the start.
+	migrateRetries int
+	// migrateResume is set to migrate only the objects the migration
+	// journal does not record as done.
+	migrateResume bool
+	// overwriteJournal is set to start a new migration journal even if
+	// the journal of the previous run is incomplete.
+	overwriteJournal bool
+)
 
 type migrateState struct {
-	objectCh chan string
+	objectCh chan Entry
 	failedCh chan migrationErr
 	logCh    chan string
 	count    uint64
 	failCnt  uint64
+	skipCnt  uint64
 	wg       sync.WaitGroup
+	// writers of the fails and success files
+	writers sync.WaitGroup
+	journal *migrationJournal
 }
 
 type migrationErr struct {
@@ -29,7 +49,15 @@ type migrationErr struct {
 	err    error
 }
 
-func (m *migrateState) queueUploadTask(obj string) {
+// errObjectSkipped is returned by migrateObject for an object that is
+// deliberately not migrated, such as a symbolic link with --symlinks skip.
+// The object is counted as skipped, neither migrated nor failed.
+var errObjectSkipped = errors.New("object skipped")
+
+func (m *migrateState) queueUploadTask(obj Entry) {
+	if err := m.journal.queued(obj.ObjectPath); err != nil {
+		console.Fatalln(fmt.Errorf("unable to write migration journal: %w", err))
+	}
 	m.objectCh <- obj
 }
 
@@ -43,7 +71,7 @@ func newMigrationState(ctx context.Context) *migrateState {
 		migrationConcurrent = runtime.GOMAXPROCS(0)
 	}
 	ms := &migrateState{
-		objectCh: make(chan string, migrationConcurrent),
+		objectCh: make(chan Entry, migrationConcurrent),
 		failedCh: make(chan migrationErr, migrationConcurrent),
 		logCh:    make(chan string, migrationConcurrent),
 	}
@@ -71,6 +99,16 @@ func (m *migrateState) getFailCount() uint64 {
 	return atomic.LoadUint64(&m.failCnt)
 }
 
+// Increase count skipped
+func (m *migrateState) incSkipCount() {
+	atomic.AddUint64(&m.skipCnt, 1)
+}
+
+// Get total count skipped
+func (m *migrateState) getSkipCount() uint64 {
+	return atomic.LoadUint64(&m.skipCnt)
+}
+
 // addWorker creates a new worker to process tasks
 func (m *migrateState) addWorker(ctx context.Context) {
 	m.wg.Add(1)
@@ -85,15 +123,27 @@ func (m *migrateState) addWorker(ctx context.Context) {
 				if !ok {
 					return
 				}
-				logDMsg(fmt.Sprintf("Migrating...%s", obj), nil)
-				if err := migrateObject(ctx, obj); err != nil {
+				logDMsg(fmt.Sprintf("Migrating...%s", obj.ObjectPath), nil)
+				attempts := m.journal.previousAttempts(obj.ObjectPath)
+				err := withRetryIf(ctx, migrateRetries, "migration of "+obj.ObjectPath, isTransientError, func() error {
+					attempts++
+					return migrateObject(ctx, obj)
+				})
+				if jerr := m.journal.finished(obj.ObjectPath, attempts, err); jerr != nil {
+					console.Fatalln(fmt.Errorf("unable to write migration journal: %w", jerr))
+				}
+				if errors.Is(err, errObjectSkipped) {
+					m.incSkipCount()
+					continue
+				}
+				if err != nil {
 					m.incFailCount()
-					logMsg(fmt.Sprintf("error migrating object %s: %s", obj, err))
-					m.failedCh <- migrationErr{object: obj, err: err}
+					logMsg(fmt.Sprintf("error migrating object %s: %s", obj.ObjectPath, err))
+					m.failedCh <- migrationErr{object: obj.ObjectPath, err: err}
 					continue
 				}
 				m.incCount()
-				m.logCh <- obj
+				m.logCh <- obj.ObjectPath
 			}
 		}
 	}()
@@ -103,9 +153,10 @@ func (m *migrateState) finish(ctx context.Context) {
 	m.wg.Wait() // wait on workers to finish
 	close(m.failedCh)
 	close(m.logCh)
+	m.writers.Wait()
 
 	if !dryRun {
-		logMsg(fmt.Sprintf("Migrated %d objects, %d failures", m.getCount(), m.getFailCount()))
+		logMsg(fmt.Sprintf("Migrated %d objects, %d failures, %d skipped", m.getCount(), m.getFailCount(), m.getSkipCount()))
 	}
 }
 func (m *migrateState) init(ctx context.Context) {
@@ -115,7 +166,9 @@ func (m *migrateState) init(ctx context.Context) {
 	for i := 0; i < migrationConcurrent; i++ {
 		m.addWorker(ctx)
 	}
+	m.writers.Add(2)
 	go func() {
+		defer m.writers.Done()
 		f, err := os.OpenFile(path.Join(dirPath, getFileName(failMigFile, "")), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 		if err != nil {
 			logDMsg("could not create + migration_fails.txt", err)
@@ -142,6 +195,7 @@ func (m *migrateState) init(ctx context.Context) {
 		}
 	}()
 	go func() {
+		defer m.writers.Done()
 		f, err := os.OpenFile(path.Join(dirPath, getFileName(logMigFile, "")), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 		if err != nil {
 			logDMsg("could not create + migration_log.txt", err)
@@ -170,27 +224,187 @@ func (m *migrateState) init(ctx context.Context) {
 
 }
 
-func migrateObject(ctx context.Context, object string) error {
-	r, oi, err := hcp.GetObject(object)
+func migrateObject(ctx context.Context, entry Entry) error {
+	if entry.EntryType == "symlink" {
+		return migrateSymlink(ctx, entry)
+	}
+	// refuse objects whose listed retention MinIO cannot hold before
+	// downloading them
+	if err := entryRetention(entry).check(); err != nil {
+		return err
+	}
+	if migrateVersions {
+		return migrateObjectVersions(ctx, entry)
+	}
+	object := entry.ObjectPath
+	r, oi, err := getObject(entry, object, "")
 	if err != nil {
 		return err
 	}
 	defer r.Close()
+	if oi.Metadata.Get(hcpHashHeader) == "" && entry.Hash != "" {
+		oi.Metadata.Set(hcpHashHeader, entry.HashScheme+" "+entry.Hash)
+	}
+	if entry.EntryType == "" && !filter.matchObjectInfo(oi) {
+		logDMsg("object "+object+" not selected by filters, not migrated", nil)
+		return errObjectSkipped
+	}
+	if oi.Key, err = objectKey(entry, oi.Size, oi.Metadata); err != nil {
+		return err
+	}
+	if renameAnnotation != "" {
+		if err = renameObject(ctx, object, &oi); err != nil {
+			return err
+		}
+	}
 	if dryRun {
 		logMsg(migrateMsg(object, oi.Key))
+		if renameAnnotation != "" {
+			return renames.add(object, oi.Key)
+		}
 		return nil
 	}
-	if _, err = minioClient.StatObject(ctx, minioBucket, oi.Key, miniogo.StatObjectOptions{}); err == nil {
-		logDMsg("object already exists on MinIO "+oi.Key+" not migrated", err)
-		return nil
+	release, err := claimKey(object, oi.Key)
+	if err != nil {
+		return err
+	}
+	defer release()
+	exists, err := existingObject(ctx, object, oi.Key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		err = uploadMissingAnnotations(ctx, oi, entry)
+	} else {
+		err = uploadWithAnnotations(ctx, r, oi, entry)
+	}
+	if err == nil && renameAnnotation != "" {
+		return renames.add(object, oi.Key)
+	}
+	return err
+}
+
+// migratingKeys holds the HCP object migrating to each MinIO object name,
+// so that objects mapped to the same name fail rather than overwrite each
+// other.
+var migratingKeys = struct {
+	sync.Mutex
+	objects map[string]string
+}{objects: make(map[string]string)}
+
+// claimKey reserves key for the migration of the HCP object at object
+// until release is called. An error is returned if another object is
+// migrating to key.
+func claimKey(object, key string) (release func(), err error) {
+	migratingKeys.Lock()
+	defer migratingKeys.Unlock()
+	if other, ok := migratingKeys.objects[key]; ok && other != object {
+		return nil, fmt.Errorf("%s is also mapped to MinIO object %s", other, key)
+	}
+	migratingKeys.objects[key] = object
+	return func() {
+		migratingKeys.Lock()
+		delete(migratingKeys.objects, key)
+		migratingKeys.Unlock()
+	}, nil
+}
+
+// existingObject reports whether the MinIO object key was already
+// migrated from the HCP object at object. An error is returned if it was
+// migrated from another HCP object, mapped to the same name.
+func existingObject(ctx context.Context, object, key string) (bool, error) {
+	oi, err := minioClient.StatObject(ctx, minioBucket, key, miniogo.StatObjectOptions{})
+	if err != nil {
+		return false, nil
+	}
+	if source := oi.Metadata.Get("X-Amz-Meta-" + sourcePathMeta); source != "" && source != object {
+		return true, fmt.Errorf("MinIO object %s was migrated from %s, which is mapped to the same name", key, source)
+	}
+	logDMsg("object already exists on MinIO "+key+" not migrated", nil)
+	return true, nil
+}
+
+// uploadWithAnnotations uploads the HCP object of entry described by oi
+// to MinIO along with its custom-metadata annotations, as set by
+// --annotations.
+func uploadWithAnnotations(ctx context.Context, r io.Reader, oi miniogo.ObjectInfo, entry Entry) error {
+	annotations, err := fetchAnnotations(ctx, entry, oi.Metadata)
+	if err != nil {
+		return err
+	}
+	if oi.UserMetadata == nil {
+		oi.UserMetadata = make(miniogo.StringMap)
+	}
+	oi.UserMetadata[sourcePathMeta] = entry.ObjectPath
+	sidecars := embedAnnotations(annotations, oi.UserMetadata)
+	if err = uploadObject(ctx, entry.ObjectPath, r, oi); err != nil {
+		return err
+	}
+	// sidecars follow their object, those of an object found on MinIO by a
+	// later run are uploaded by uploadMissingAnnotations
+	return uploadAnnotations(ctx, oi, sidecars)
+}
+
+// getObject fetches the object, or version, of entry from HCP. Large
+// objects are fetched in ranges by uploadObject, only their headers are
+// fetched here, with a HEAD if the listing tells they are large.
+func getObject(entry Entry, object, versionID string) (io.ReadCloser, miniogo.ObjectInfo, error) {
+	if size := listedSize(entry); multipartThreshold > 0 && size >= multipartThreshold {
+		oi, err := hcp.HeadObject(object, versionID)
+		return http.NoBody, oi, err
 	}
-	uoi, err := minioClient.PutObject(ctx, minioBucket, oi.Key, r, oi.Size, miniogo.PutObjectOptions{
+	r, oi, err := hcp.GetObject(object, versionID)
+	if err == nil && isLargeObject(oi) {
+		r.Close()
+		r = http.NoBody
+	}
+	return r, oi, err
+}
+
+// uploadObject uploads the content of the HCP object at object, described
+// by oi and read from r, to MinIO, with its content type, the allowed HCP
+// system metadata and the retention and legal hold HCP reports for it.
+// With --verify the content is checked against the hash HCP reports for
+// it on the way. Large objects are fetched again in parallel ranges
+// instead of read from r.
+func uploadObject(ctx context.Context, object string, r io.Reader, oi miniogo.ObjectInfo) error {
+	meta := systemMetadata(oi.Metadata)
+	for k, v := range oi.UserMetadata {
+		meta[k] = v
+	}
+	opts := miniogo.PutObjectOptions{
+		ContentType:  oi.ContentType,
+		UserMetadata: meta,
 		Internal: miniogo.AdvancedPutOptions{
 			SourceMTime: oi.LastModified,
 		},
-	})
+	}
+	ret, err := headerRetention(oi.Metadata)
+	if err != nil {
+		return err
+	}
+	if err = ret.apply(&opts); err != nil {
+		return err
+	}
+	if isLargeObject(oi) {
+		return uploadLargeObject(ctx, object, oi, opts)
+	}
+	var vr *verifyingReader
+	if verifyContent {
+		if vr, err = newVerifyingReader(r, oi.Size, oi.Metadata.Get(hcpHashHeader)); err != nil {
+			return err
+		}
+		r = vr
+		meta[verifiedHashMeta] = vr.String()
+	}
+	uoi, err := minioClient.PutObject(ctx, minioBucket, oi.Key, r, oi.Size, opts)
 	if err != nil {
 		logDMsg("upload to minio failed for "+oi.Key, err)
+		// the mismatch comes back wrapped in the error of the request it
+		// failed, which reads as a transient network error
+		if vr != nil && vr.err != nil {
+			return vr.err
+		}
 		return err
 	}
 	if uoi.Size != oi.Size {
@@ -198,6 +412,12 @@ func migrateObject(ctx context.Context, object string) error {
 		logDMsg("upload to minio failed for "+oi.Key, err)
 		return err
 	}
+	if vr != nil {
+		if err = vr.verified(); err != nil {
+			logDMsg("upload to minio failed for "+oi.Key, err)
+			return err
+		}
+	}
 	logDMsg("Uploaded "+uoi.Key+" successfully", nil)
 	return nil
 }
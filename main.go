@@ -20,6 +20,7 @@ import (
 	"os"
 
 	"github.com/minio/cli"
+	"github.com/minio/minio/pkg/console"
 )
 
 var version = "(dev)"
@@ -27,6 +28,12 @@ var version = "(dev)"
 var subcommands = []cli.Command{
 	listCmd,
 	migrateCmd,
+	discoverCmd,
+	namespacesCmd,
+	provisionCmd,
+	mapTestCmd,
+	cleanupUploadsCmd,
+	retryFailedCmd,
 }
 
 // mainAction is the handle for "hcp-to-minio" command.
@@ -36,10 +43,13 @@ func mainAction(ctx *cli.Context) error {
 		os.Exit(1)
 	}
 	command := ctx.Args().First()
-	if command != "list" && command != "migrate" {
-		cli.ShowCommandHelp(ctx, "")
-		os.Exit(1)
+	for _, cmd := range subcommands {
+		if cmd.Name == command {
+			return nil
+		}
 	}
+	cli.ShowCommandHelp(ctx, "")
+	os.Exit(1)
 	return nil
 }
 
@@ -52,5 +62,7 @@ func main() {
 	app.Flags = []cli.Flag{}
 	app.Action = mainAction
 	app.Commands = subcommands
-	app.Run(os.Args)
+	if err := app.Run(os.Args); err != nil {
+		console.Fatalln(err)
+	}
 }
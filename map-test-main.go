@@ -0,0 +1,154 @@
+/*
+ * MinIO Client (C) 2021 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/minio/cli"
+	"github.com/minio/minio/pkg/console"
+)
+
+var mapTestFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "input-file",
+		Usage: "listing to map the objects of, in text or jsonl format, instead of the paths given as arguments",
+	},
+	cli.StringFlag{
+		Name:  "size",
+		Usage: "size of the objects given as arguments, e.g. 10MiB",
+	},
+	cli.StringFlag{
+		Name:  "ingest-time",
+		Usage: "ingest time of the objects given as arguments (RFC3339 or YYYY-MM-DD)",
+	},
+}
+
+var mapTestCmd = cli.Command{
+	Name:   "map-test",
+	Usage:  "Show the MinIO object names HCP objects would be migrated to",
+	Action: mapTestAction,
+	Flags:  append(keymapFlags, mapTestFlags...),
+	CustomHelpTemplate: `NAME:
+  {{.HelpName}} - {{.Usage}}
+
+USAGE:
+  {{.HelpName}} [--strip-prefix, --key-rule, --key-template, --key-prefix] [--size, --ingest-time] PATH... | --input-file
+
+FLAGS:
+  {{range .VisibleFlags}}{{.}}
+  {{end}}
+
+Paths are HCP object paths, with or without the leading /rest/. Objects of a jsonl listing are
+mapped with their own size and ingest time. A --key-template using .Size or .IngestTime fails for
+objects whose size or ingest time is not known, as migrate does for the symbolic links of a text listing.
+
+EXAMPLES:
+1. Show the name of an object ingested in June 2020 when mapped by ingest month and extension
+   $ hcp-to-minio map-test --strip-prefix archive/ --key-template '{{"{{"}}.IngestTime.Format "2006/01"}}/{{"{{"}}lower .Ext}}/{{"{{"}}.Path}}' \
+		--ingest-time 2020-06-15 archive/a/b.PDF
+
+2. Show the names of all objects of a listing when moved from reports/<year>/ to <year>/reports/ under prefix hcp/
+   $ hcp-to-minio map-test --key-rule '^reports/(\d{4})/=>$1/reports/' --key-prefix hcp/ \
+		--input-file /tmp/data/object_listing.jsonl
+`,
+}
+
+// hcpObjectPath returns the HCP path of an object given with or without
+// the leading /rest/.
+func hcpObjectPath(p string) string {
+	if strings.HasPrefix(p, "/rest/") {
+		return p
+	}
+	return "/rest/" + strings.TrimPrefix(p, "/")
+}
+
+func mapTestAction(cliCtx *cli.Context) error {
+	var err error
+	if keyMap, err = parseKeyMapFlags(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	inputFile := cliCtx.String("input-file")
+	if inputFile == "" && !cliCtx.Args().Present() {
+		cli.ShowCommandHelp(cliCtx, cliCtx.Command.Name)
+		console.Fatalln(fmt.Errorf("paths or --input-file required"))
+	}
+	var failed int
+	mapEntry := func(entry Entry, size int64) {
+		key, err := objectKey(entry, size, nil)
+		if err != nil {
+			console.Errorln(err)
+			failed++
+			return
+		}
+		fmt.Printf("%s -> %s\n", entry.ObjectPath, key)
+	}
+	if inputFile != "" {
+		f, err := os.Open(inputFile)
+		if err != nil {
+			console.Fatalln(err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			entry, err := parseListingLine(line)
+			if err != nil {
+				console.Errorln(fmt.Errorf("malformed entry in %s: %w", inputFile, err))
+				failed++
+				continue
+			}
+			mapEntry(entry, listedSize(entry))
+		}
+		if err = scanner.Err(); err != nil {
+			return err
+		}
+	} else {
+		var entry Entry
+		size := int64(unknownSize)
+		if s := cliCtx.String("size"); s != "" {
+			n, err := humanize.ParseBytes(s)
+			if err != nil {
+				console.Fatalln(fmt.Errorf("invalid --size %s: %w", s, err))
+			}
+			size = int64(n)
+		}
+		if s := cliCtx.String("ingest-time"); s != "" {
+			t, err := parseSince(s)
+			if err != nil {
+				console.Fatalln(fmt.Errorf("invalid --ingest-time %s: %w", s, err))
+			}
+			entry.IngestTime = t.Unix()
+		}
+		for _, p := range cliCtx.Args() {
+			entry.ObjectPath = hcpObjectPath(p)
+			mapEntry(entry, size)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("unable to map %d objects", failed)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/minio/cli"
+)
+
+// hcpSystemMetadata maps the names accepted by --hcp-metadata to the HCP
+// response header carrying the value. Each is stored on MinIO as user
+// metadata x-amz-meta-hcp-<name>.
+var hcpSystemMetadata = map[string]string{
+	"ingest-time": "X-HCP-IngestTime",
+	"hash":        "X-HCP-Hash",
+	"dpl":         "X-HCP-DPL",
+	"index":       "X-HCP-Index",
+	"shred":       "X-HCP-Shred",
+	"owner":       "X-HCP-Owner",
+	"domain":      "X-HCP-Domain",
+	"replicated":  "X-HCP-Replicated",
+	"change-time": "X-HCP-ChangeTimeMilliseconds",
+	"version-id":  "X-HCP-VersionId",
+}
+
+const defaultHCPMetadata = "ingest-time,hash,dpl,index,shred,owner"
+
+// sourcePathMeta is the user metadata naming the HCP object a MinIO object
+// was migrated from, whatever --hcp-metadata. It tells apart the objects
+// mapped to the same MinIO object name.
+const sourcePathMeta = "hcp-source-path"
+
+var metadataFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "hcp-metadata",
+		Usage: "comma separated HCP system metadata to keep as x-amz-meta-hcp-* user metadata, 'none' to keep none",
+		Value: defaultHCPMetadata,
+	},
+}
+
+// hcpMetadataNames is the allow-list of system metadata set by
+// --hcp-metadata.
+var hcpMetadataNames []string
+
+func parseMetadataFlag(ctx *cli.Context) error {
+	hcpMetadataNames = nil
+	v := ctx.String("hcp-metadata")
+	if v == "" || v == "none" {
+		return nil
+	}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := hcpSystemMetadata[name]; !ok {
+			var known []string
+			for k := range hcpSystemMetadata {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown --hcp-metadata %s, must be one of %s", name, strings.Join(known, ", "))
+		}
+		hcpMetadataNames = append(hcpMetadataNames, name)
+	}
+	return nil
+}
+
+// systemMetadata returns the user metadata for the allowed HCP system
+// metadata in the headers of a GET on an object.
+func systemMetadata(h http.Header) map[string]string {
+	meta := make(map[string]string)
+	for _, name := range hcpMetadataNames {
+		if v := h.Get(hcpSystemMetadata[name]); v != "" {
+			meta["hcp-"+name] = v
+		}
+	}
+	return meta
+}
@@ -21,10 +21,12 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
@@ -37,28 +39,57 @@ import (
 var migrateFlags = []cli.Flag{
 	cli.IntFlag{
 		Name:  "skip, s",
-		Usage: "number of entries to skip from input file",
+		Usage: "number of entries to skip from input file, deprecated in favor of --resume",
 		Value: 0,
 	},
+	cli.BoolFlag{
+		Name:  "resume",
+		Usage: "migrate only the objects not done according to the migration journal in --data-dir, of the listing of the previous run if --input-file is not set",
+	},
+	cli.BoolFlag{
+		Name:  "overwrite-journal",
+		Usage: "start a new migration journal in --data-dir even if the journal of the previous run records objects not migrated yet",
+	},
 	cli.BoolFlag{
 		Name:  "fake",
 		Usage: "perform a fake migration",
 	},
 	cli.StringFlag{
 		Name:  "input-file",
-		Usage: "file with list of entries to migrate from HCP",
+		Usage: "file with list of entries to migrate from HCP, in text or jsonl format",
 	},
+	cli.IntFlag{
+		Name:  "retries",
+		Usage: "number of times to retry migrating an object after a transient HCP or MinIO failure",
+		Value: 5,
+	},
+	cli.BoolFlag{
+		Name:  "versions",
+		Usage: "migrate every version of each object, oldest first, into a versioned bucket",
+	},
+	planFileFlag,
 }
+
+// migrateCommonFlags are the flags of how objects are migrated, shared by
+// migrate and retry-failed and read by parseMigrateSettings.
+var migrateCommonFlags = func() []cli.Flag {
+	var flags []cli.Flag
+	for _, f := range [][]cli.Flag{filterFlags, symlinkFlags, retentionFlags, metadataFlags, annotationFlags, renameFlags, keymapFlags, verifyFlags, largeObjectFlags} {
+		flags = append(flags, f...)
+	}
+	return flags
+}()
+
 var migrateCmd = cli.Command{
 	Name:   "migrate",
 	Usage:  "Migrate HCP objects to MinIO",
 	Action: migrateAction,
-	Flags:  append(allFlags, migrateFlags...),
+	Flags:  append(append(allFlags, migrateFlags...), migrateCommonFlags...),
 	CustomHelpTemplate: `NAME:
 	{{.HelpName}} - {{.Usage}}
 
 USAGE:
-	{{.HelpName}} --auth-token --namespace-url --host-header --data-dir [--skip, --fake]
+	{{.HelpName}} --auth-token --namespace-url --host-header --data-dir [--resume, --overwrite-journal, --fake, --retries, --versions, --include, --exclude, --min-size, --max-size, --ingested-before, --ingested-after, --symlinks, --retention-mode, --retention-class-mode, --hcp-metadata, --annotations, --annotation-prefix, --annotation-suffix, --rename-from-annotation, --strip-prefix, --key-rule, --key-template, --key-prefix, --verify, --multipart-threshold, --part-size, --part-workers, --plan-file]
 
 FLAGS:
    {{range .VisibleFlags}}{{.}}
@@ -74,14 +105,15 @@ EXAMPLES:
 			--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
 			--input-file "/tmp/data/to_migrate.txt"
 
-2. Migrate objects in input file from HCP to MinIO after skipping 100000 entries in this file
+2. Resume an interrupted migration, migrating the objects of its listing that are not done according to the
+   migration journal in /tmp/data
    $ export MINIO_ENDPOINT=https://minio:9000
    $ export MINIO_ACCESS_KEY=minio
    $ export MINIO_SECRET_KEY=minio123
    $ export MINIO_BUCKET=miniobucket
    $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
  			--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
-			--skip 100000 --input-file "/tmp/data/to_migrate.txt"
+			--resume
 
 3. Perform a dry run for migrating objects in input file from HCP to MinIO
    $ export MINIO_ENDPOINT=https://minio:9000
@@ -91,6 +123,97 @@ EXAMPLES:
    $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
 		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
 		--fake --log --input-file "/tmp/data/to_migrate.txt"
+
+4. Migrate every version of the objects in a listing made with --versions from HCP to a versioned MinIO bucket
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--versions --input-file "/tmp/data/object_listing.jsonl"
+
+5. Migrate only the PDF documents of at most 100MiB ingested before 2020 from a listing
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--include "**/*.pdf" --max-size 100MiB --ingested-before 2020-01-01 --input-file "/tmp/data/object_listing.jsonl"
+
+6. Migrate the symbolic links in a listing made with --symlinks link as empty objects naming their target
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--symlinks link --input-file "/tmp/data/object_listing.jsonl"
+
+7. Migrate objects under HCP retention to a bucket with object lock, in COMPLIANCE mode for retention class "sec17a4"
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--retention-class-mode sec17a4=COMPLIANCE --input-file "/tmp/data/object_listing.jsonl"
+
+8. Migrate objects keeping only their HCP ingest time and hash as x-amz-meta-hcp-ingest-time and x-amz-meta-hcp-hash
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--hcp-metadata ingest-time,hash --input-file "/tmp/data/to_migrate.txt"
+
+9. Migrate objects with their custom-metadata annotations as sidecar objects under annotations/, e.g. annotations/a/b.pdf.default.xml
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--annotations sidecar --annotation-prefix annotations/ --annotation-suffix . --input-file "/tmp/data/to_migrate.txt"
+
+10. Migrate report objects to names and metadata derived from the report document in their "report" annotation
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--rename-from-annotation report --input-file "/tmp/data/to_migrate.txt"
+
+11. Migrate objects under archive/ to names by ingest month, e.g. archive/a/b.pdf to 2020/06/pdf/a/b.pdf. Try the mapping with map-test first
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" --strip-prefix archive/ \
+		--key-template '{{"{{"}}.IngestTime.Format "2006/01"}}/{{"{{"}}lower .Ext}}/{{"{{"}}.Path}}' --input-file "/tmp/data/object_listing.jsonl"
+
+12. Migrate objects verifying their content against the hash reported by HCP, recorded as x-amz-meta-hcp-verified-hash
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--verify --input-file "/tmp/data/to_migrate.txt"
+
+13. Migrate objects of 512MiB and more in parts of 256MiB, 8 parts of an object at a time, resuming the
+    multipart uploads recorded in /tmp/data by a previous run
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+		--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" \
+		--multipart-threshold 512MiB --part-size 256MiB --part-workers 8 --input-file "/tmp/data/to_migrate.txt"
 `,
 }
 var minioClient *miniogo.Client
@@ -124,7 +247,7 @@ func initMinioClient(ctx *cli.Context) error {
 	// if minioBucket == "" {
 	// 	minioBucket = bucket
 	// }
-	if accessKey == "" || secretKey == "" || minioBucket == "" {
+	if accessKey == "" || secretKey == "" {
 		console.Fatalln(fmt.Errorf("one or more of AccessKey:%s SecretKey: %s Bucket:%s ", accessKey, secretKey, bucket), "are missing in MinIO configuration")
 	}
 	options := miniogo.Options{
@@ -171,6 +294,44 @@ func initMinioClient(ctx *cli.Context) error {
 	return nil
 }
 
+// parseMigrateSettings sets how objects are migrated from the flags
+// shared by migrate and retry-failed.
+func parseMigrateSettings(cliCtx *cli.Context) {
+	dryRun = cliCtx.Bool("fake")
+	migrateVersions = cliCtx.Bool("versions")
+	migrateRetries = cliCtx.Int("retries")
+	if err := parseFilterFlags(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	if err := parseSymlinkFlag(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	if err := parseRetentionFlags(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	if err := parseMetadataFlag(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	if err := parseAnnotationFlags(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	verifyContent = cliCtx.Bool("verify")
+	if err := parseLargeObjectFlags(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	renameAnnotation = cliCtx.String("rename-from-annotation")
+	if renameAnnotation != "" && migrateVersions {
+		console.Fatalln(fmt.Errorf("--rename-from-annotation is not supported with --versions"))
+	}
+	var err error
+	if keyMap, err = parseKeyMapFlags(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	if keyMap != nil && renameAnnotation != "" {
+		console.Fatalln(fmt.Errorf("--rename-from-annotation cannot be used with --strip-prefix, --key-rule, --key-template or --key-prefix"))
+	}
+}
+
 func migrateAction(cliCtx *cli.Context) error {
 	checkArgsAndInit(cliCtx)
 	ctx := context.Background()
@@ -180,38 +341,155 @@ func migrateAction(cliCtx *cli.Context) error {
 		cli.ShowCommandHelp(cliCtx, cliCtx.Command.Name) // last argument is exit code
 		console.Fatalln(err)
 	}
-	migrationState = newMigrationState(ctx)
-	migrationState.init(ctx)
+	if minioBucket == "" && planFilePath == "" {
+		console.Fatalln(fmt.Errorf("MINIO_BUCKET needs to be set"))
+	}
 	skip := cliCtx.Int("skip")
-	dryRun = cliCtx.Bool("fake")
-	start := time.Now()
+	migrateResume = cliCtx.Bool("resume")
+	overwriteJournal = cliCtx.Bool("overwrite-journal")
+	if migrateResume && overwriteJournal {
+		console.Fatalln(fmt.Errorf("--overwrite-journal cannot be used with --resume"))
+	}
+	if skip > 0 {
+		if migrateResume {
+			console.Fatalln(fmt.Errorf("--skip cannot be used with --resume"))
+		}
+		logMsg("--skip is deprecated, use --resume to continue an interrupted migration")
+	}
+	parseMigrateSettings(cliCtx)
 	inputFile := cliCtx.String("input-file")
+	if planFilePath != "" {
+		if inputFile != "" || skip > 0 {
+			console.Fatalln(fmt.Errorf("--input-file and --skip are not supported with --plan-file"))
+		}
+		return forEachPlannedNamespace(func(ns plannedNamespace) error {
+			minioBucket = ns.Bucket
+			listFile, err := completeListing()
+			if err != nil {
+				return err
+			}
+			return migrateListing(ctx, listFile, 0)
+		})
+	}
+	if inputFile == "" && !migrateResume {
+		console.Fatalln("--input-file needs to be specified")
+	}
+	return migrateListing(ctx, inputFile, skip)
+}
+
+// migrateListing migrates the objects of the listing in inputFile to
+// minioBucket, after skipping the first skip entries. With --resume the
+// objects the migration journal records as done are skipped, and the
+// listing is that of the journal if inputFile is empty.
+func migrateListing(ctx context.Context, inputFile string, skip int) error {
+	journal, inputFile, err := openMigrationJournal(inputFile)
+	if err != nil {
+		return err
+	}
 	file, err := os.Open(inputFile)
 	if err != nil {
-		console.Fatalln("--input-file needs to be specified", err)
+		journal.Close()
+		return err
+	}
+	defer file.Close()
+	return migrateEntries(ctx, file, inputFile, skip == 0, skip, journal, nil)
+}
+
+// migrateEntries migrates the objects of the listing read from r, named
+// inputFile, to minioBucket, journaling them in journal which it closes.
+// If whole is set r holds the whole listing of journal, journaled as
+// listed once all its objects are queued. The failures in carried, of
+// objects not migrated again, are recorded in the fails file of the run
+// along with the new ones.
+func migrateEntries(ctx context.Context, r io.Reader, inputFile string, whole bool, skip int, journal *migrationJournal, carried []migrationErr) (err error) {
+	defer func() {
+		if cerr := journal.Close(); cerr != nil {
+			console.Errorln(fmt.Errorf("unable to write migration journal: %w", cerr))
+		}
+	}()
+	if migrateVersions && !dryRun {
+		vcfg, err := minioClient.GetBucketVersioning(ctx, minioBucket)
+		if err != nil {
+			return fmt.Errorf("unable to get versioning configuration of bucket %s: %w", minioBucket, err)
+		}
+		if vcfg.Status != "Enabled" {
+			return fmt.Errorf("--versions requires versioning to be enabled on bucket %s", minioBucket)
+		}
+	}
+	if renameAnnotation != "" {
+		if renames, err = newRenameMap(); err != nil {
+			return err
+		}
+		defer renames.Close()
 	}
-	scanner := bufio.NewScanner(file)
+	if !dryRun {
+		if err = loadLargeUploads(); err != nil {
+			return err
+		}
+	}
+	migrationState = newMigrationState(ctx)
+	migrationState.journal = journal
+	migrationState.init(ctx)
+	for _, me := range carried {
+		migrationState.failedCh <- me
+	}
+	start := time.Now()
+	var done int
+	scanner := bufio.NewScanner(r)
+	// jsonl entries listed with --versions hold every version of an object
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
 	for scanner.Scan() {
-		o := scanner.Text()
+		line := scanner.Text()
+		// skip blank lines and the header of listing shards
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
 		if skip > 0 {
 			skip--
 			continue
 		}
+		o, err := parseListingLine(line)
+		if err != nil {
+			// recorded as failed under the line itself, nothing else
+			// identifies the object
+			logMsg(fmt.Sprintf("malformed entry in %s: %s", inputFile, err))
+			migrationState.incFailCount()
+			migrationState.failedCh <- migrationErr{object: line, err: fmt.Errorf("malformed entry in %s: %w", inputFile, err)}
+			continue
+		}
+		if !filter.matchEntry(o) {
+			continue
+		}
+		if journal.isDone(o.ObjectPath) {
+			done++
+			continue
+		}
 		migrationState.queueUploadTask(o)
-		logDMsg(fmt.Sprintf("adding %s to migration queue", o), nil)
+		logDMsg(fmt.Sprintf("adding %s to migration queue", o.ObjectPath), nil)
 	}
 	if err := scanner.Err(); err != nil {
 		logDMsg(fmt.Sprintf("error processing file :%s ", objListFile), err)
 		return err
 	}
+	if whole {
+		if err := journal.listed(); err != nil {
+			console.Fatalln(fmt.Errorf("unable to write migration journal: %w", err))
+		}
+	}
 	migrationState.finish(ctx)
+	closeLargeUploads()
+	if done > 0 {
+		logMsg(fmt.Sprintf("Skipped %s objects already migrated according to %s", humanize.Comma(int64(done)), migrationJournalFile))
+	}
 	if dryRun {
 		logMsg("Migration dry run complete")
 	} else {
 		end := time.Now()
 		latency := end.Sub(start).Seconds()
-		count := migrationState.getCount() - migrationState.getFailCount()
-		logMsg(fmt.Sprintf("Migrated %s / %s objects with latency %d secs", humanize.Comma(int64(count)), humanize.Comma(int64(migrationState.getCount())), int64(latency)))
+		count := migrationState.getCount()
+		skipped := migrationState.getSkipCount()
+		total := count + migrationState.getFailCount()
+		logMsg(fmt.Sprintf("Migrated %s / %s objects with latency %d secs, %s skipped", humanize.Comma(int64(count)), humanize.Comma(int64(total)), int64(latency), humanize.Comma(int64(skipped))))
 		hcp.printLatencyStats()
 	}
 	return nil
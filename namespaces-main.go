@@ -0,0 +1,158 @@
+/*
+ * MinIO Client (C) 2021 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/minio/cli"
+	"github.com/minio/minio/pkg/console"
+)
+
+// mapiFlags are the flags of the commands using the HCP Management API.
+var mapiFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "auth-token, a",
+		Usage: "authorization token for HCP",
+	},
+	cli.StringFlag{
+		Name:  "mapi-url",
+		Usage: "HCP Management API URL of the tenant, e.g https://tenant-name.hcp-domain-name:9090",
+	},
+	cli.StringFlag{
+		Name:  "host-header",
+		Usage: "host header of the tenant, if --mapi-url does not name it",
+	},
+	cli.StringFlag{
+		Name:  "tenant",
+		Usage: "name of the tenant, defaults to the first label of the tenant host name",
+	},
+	cli.StringFlag{
+		Name:  "bucket-prefix",
+		Usage: "prefix of the MinIO bucket name of each namespace",
+	},
+	cli.BoolFlag{
+		Name:  "insecure, i",
+		Usage: "disable TLS certificate verification",
+	},
+	cli.BoolFlag{
+		Name:  "log, l",
+		Usage: "enable logging",
+	},
+	cli.BoolFlag{
+		Name:  "debug",
+		Usage: "enable debugging",
+	},
+}
+
+var namespacesFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "data-dir, d",
+		Usage: "path to work directory for tool",
+	},
+}
+
+var namespacesCmd = cli.Command{
+	Name:   "namespaces",
+	Usage:  "Write a plan of all namespaces of an HCP tenant for list and migrate",
+	Action: namespacesAction,
+	Flags:  append(mapiFlags, namespacesFlags...),
+	CustomHelpTemplate: `NAME:
+  {{.HelpName}} - {{.Usage}}
+
+USAGE:
+  {{.HelpName}} --auth-token --mapi-url --data-dir [--host-header, --tenant, --bucket-prefix]
+
+FLAGS:
+  {{range .VisibleFlags}}{{.}}
+  {{end}}
+
+The plan file records the URL, host header and settings of each namespace along with the MinIO
+bucket to migrate it to. It may be edited to leave out namespaces or rename buckets before it is
+given to list and migrate with --plan-file.
+
+EXAMPLES:
+1. Write the plan of all namespaces of tenant "finance" to /tmp/data
+   $ hcp-to-minio namespaces -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" \
+		--mapi-url "https://finance.hcp.example.com:9090" --data-dir "/tmp/data" --bucket-prefix "finance-"
+
+2. List every namespace of the plan, each into its own directory under /tmp/data
+   $ hcp-to-minio list -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --data-dir "/tmp/data" \
+		--plan-file /tmp/data/namespaces_plan.json_finance.10-01-2021-09-30-00
+
+3. Migrate every namespace of the plan to its bucket
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ hcp-to-minio migrate -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --data-dir "/tmp/data" \
+		--plan-file /tmp/data/namespaces_plan.json_finance.10-01-2021-09-30-00
+`,
+}
+
+// initMapi sets the HCP backend for the HCP Management API from the
+// flags and returns the API URL and the name of the tenant.
+func initMapi(cliCtx *cli.Context) (mapiURL, tenant string) {
+	authToken = cliCtx.String("auth-token")
+	hostHeader = cliCtx.String("host-header")
+	debugFlag = cliCtx.Bool("debug")
+	logFlag = cliCtx.Bool("log")
+	mapiURL = cliCtx.String("mapi-url")
+	if authToken == "" || mapiURL == "" {
+		cli.ShowCommandHelp(cliCtx, cliCtx.Command.Name)
+		console.Fatalln(fmt.Errorf("--auth-token and --mapi-url required"))
+	}
+	u, err := url.Parse(mapiURL)
+	if err != nil {
+		console.Fatalln("--mapi-url malformed", mapiURL)
+	}
+	tenant = cliCtx.String("tenant")
+	if tenant == "" {
+		host := u.Hostname()
+		if hostHeader != "" {
+			host = namespaceHost()
+		}
+		tenant = strings.SplitN(host, ".", 2)[0]
+	}
+	hcp = &hcpBackend{
+		authToken:  authToken,
+		hostHeader: hostHeader,
+		Insecure:   cliCtx.Bool("insecure"),
+	}
+	return mapiURL, tenant
+}
+
+func namespacesAction(cliCtx *cli.Context) error {
+	mapiURL, tenant := initMapi(cliCtx)
+	dirPath = cliCtx.String("data-dir")
+	if dirPath == "" {
+		console.Fatalln(fmt.Errorf("path to working dir required, please set --data-dir flag"))
+	}
+	plan, err := hcp.discoverNamespaces(context.Background(), mapiURL, tenant, cliCtx.String("bucket-prefix"))
+	if err != nil {
+		return err
+	}
+	name := getFileName(planFile, tenant)
+	if err = writePlan(path.Join(dirPath, name), plan); err != nil {
+		return err
+	}
+	console.Infoln(fmt.Sprintf("Wrote plan of %d namespaces of tenant %s to %s", len(plan.Namespaces), tenant, name))
+	return nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/minio/cli"
+	"github.com/minio/minio/pkg/console"
+)
+
+const planFile = "namespaces_plan.json"
+
+var planFileFlag = cli.StringFlag{
+	Name:  "plan-file",
+	Usage: "plan file written by the namespaces command, to run for each namespace in it with its own directory under --data-dir",
+}
+
+var planFilePath string
+
+// mapiNamespace holds the settings of a namespace returned by the HCP
+// Management API.
+type mapiNamespace struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	HardQuota          string `json:"hardQuota"`
+	SoftQuota          int    `json:"softQuota"`
+	EnterpriseMode     bool   `json:"enterpriseMode"`
+	VersioningSettings struct {
+		Enabled   bool `json:"enabled"`
+		Prune     bool `json:"prune"`
+		PruneDays int  `json:"pruneDays"`
+	} `json:"versioningSettings"`
+}
+
+// mapiComplianceSettings holds the retention settings of a namespace.
+type mapiComplianceSettings struct {
+	RetentionDefault string `json:"retentionDefault"`
+	ShreddingDefault bool   `json:"shreddingDefault"`
+}
+
+// migrationPlan is the plan file written by the namespaces command. It
+// may be edited before it is given to list and migrate, e.g. to leave out
+// namespaces or rename buckets.
+type migrationPlan struct {
+	Tenant     string             `json:"tenant"`
+	Namespaces []plannedNamespace `json:"namespaces"`
+}
+
+// plannedNamespace is a namespace of the plan with the MinIO bucket it
+// is migrated to.
+type plannedNamespace struct {
+	Name             string `json:"name"`
+	NamespaceURL     string `json:"namespaceURL"`
+	HostHeader       string `json:"hostHeader"`
+	Bucket           string `json:"bucket"`
+	Versioning       bool   `json:"versioning"`
+	VersionPruneDays int    `json:"versionPruneDays,omitempty"`
+	RetentionMode    string `json:"retentionMode"` // enterprise or compliance
+	DefaultRetention string `json:"defaultRetention,omitempty"`
+	HardQuota        string `json:"hardQuota,omitempty"`
+	HardQuotaBytes   int64  `json:"hardQuotaBytes,omitempty"`
+	SoftQuotaPercent int    `json:"softQuotaPercent,omitempty"`
+}
+
+// mapiGet sends a GET request for resource to the HCP Management API at
+// mapiURL and decodes the JSON response into v.
+func (hcp *hcpBackend) mapiGet(ctx context.Context, mapiURL, resource string, v interface{}) error {
+	u, err := url.Parse(mapiURL)
+	if err != nil {
+		return err
+	}
+	u.Path = path.Join("/mapi", resource)
+	u.RawQuery = "verbose=true"
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", authToken)
+	req.Header.Set("Accept", "application/json")
+	if hostHeader != "" {
+		req.Host = namespaceHost()
+	}
+	resp, err := hcp.Client().Do(req)
+	if debugFlag {
+		console.Println(trace(req, resp))
+	}
+	if err != nil {
+		return err
+	}
+	defer closeResponse(resp)
+	if resp.StatusCode != http.StatusOK {
+		return newHCPError(resp)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding %s: %w", resource, err)
+	}
+	return nil
+}
+
+// discoverNamespaces returns the plan for all namespaces of tenant, with
+// the bucket of each named after it with bucketPrefix in front.
+func (hcp *hcpBackend) discoverNamespaces(ctx context.Context, mapiURL, tenant, bucketPrefix string) (*migrationPlan, error) {
+	var names struct {
+		Name []string `json:"name"`
+	}
+	if err := hcp.mapiGet(ctx, mapiURL, path.Join("tenants", tenant, "namespaces"), &names); err != nil {
+		return nil, fmt.Errorf("unable to list namespaces of tenant %s: %w", tenant, err)
+	}
+	plan := &migrationPlan{Tenant: tenant}
+	for _, name := range names.Name {
+		p, err := hcp.planNamespace(ctx, mapiURL, tenant, name, bucketPrefix)
+		if err != nil {
+			return nil, err
+		}
+		plan.Namespaces = append(plan.Namespaces, p)
+		logMsg(fmt.Sprintf("Found namespace %s, to migrate to bucket %s", name, p.Bucket))
+	}
+	if err := plan.checkBuckets(); err != nil {
+		return nil, fmt.Errorf("%w, use another --bucket-prefix or rename the namespaces", err)
+	}
+	return plan, nil
+}
+
+// checkBuckets returns an error if two namespaces of the plan are
+// migrated to the same bucket.
+func (plan *migrationPlan) checkBuckets() error {
+	namespaces := make(map[string]string)
+	for _, ns := range plan.Namespaces {
+		if other, ok := namespaces[ns.Bucket]; ok {
+			return fmt.Errorf("namespaces %s and %s both migrate to bucket %s", other, ns.Name, ns.Bucket)
+		}
+		namespaces[ns.Bucket] = ns.Name
+	}
+	return nil
+}
+
+// planNamespace returns the plan for namespace name of tenant from its
+// settings in the HCP Management API.
+func (hcp *hcpBackend) planNamespace(ctx context.Context, mapiURL, tenant, name, bucketPrefix string) (plannedNamespace, error) {
+	nsPath := path.Join("tenants", tenant, "namespaces", name)
+	var ns mapiNamespace
+	if err := hcp.mapiGet(ctx, mapiURL, nsPath, &ns); err != nil {
+		return plannedNamespace{}, fmt.Errorf("unable to get settings of namespace %s: %w", name, err)
+	}
+	var cs mapiComplianceSettings
+	if err := hcp.mapiGet(ctx, mapiURL, path.Join(nsPath, "complianceSettings"), &cs); err != nil {
+		return plannedNamespace{}, fmt.Errorf("unable to get compliance settings of namespace %s: %w", name, err)
+	}
+	// the namespace is served over the same scheme as the API
+	u, err := url.Parse(mapiURL)
+	if err != nil {
+		return plannedNamespace{}, err
+	}
+	host := ns.FullyQualifiedName
+	if host == "" {
+		tenantHost := u.Hostname()
+		if hostHeader != "" {
+			tenantHost = namespaceHost()
+		}
+		host = strings.ToLower(name + "." + tenantHost)
+	}
+	p := plannedNamespace{
+		Name:             name,
+		NamespaceURL:     u.Scheme + "://" + host + "/rest",
+		HostHeader:       host,
+		Bucket:           bucketName(bucketPrefix + name),
+		Versioning:       ns.VersioningSettings.Enabled,
+		RetentionMode:    "compliance",
+		DefaultRetention: cs.RetentionDefault,
+		HardQuota:        ns.HardQuota,
+		SoftQuotaPercent: ns.SoftQuota,
+	}
+	if ns.EnterpriseMode {
+		p.RetentionMode = "enterprise"
+	}
+	if ns.VersioningSettings.Prune {
+		p.VersionPruneDays = ns.VersioningSettings.PruneDays
+	}
+	if ns.HardQuota != "" {
+		if p.HardQuotaBytes, err = parseQuota(ns.HardQuota); err != nil {
+			return plannedNamespace{}, fmt.Errorf("invalid hard quota %s of namespace %s: %w", ns.HardQuota, name, err)
+		}
+	}
+	return p, nil
+}
+
+// parseQuota returns the bytes of an HCP quota such as "50.00 GB". HCP
+// quotas are in binary units.
+func parseQuota(quota string) (int64, error) {
+	q := strings.TrimSpace(quota)
+	for _, unit := range []string{"KB", "MB", "GB", "TB", "PB"} {
+		if strings.HasSuffix(q, unit) {
+			q = strings.TrimSuffix(q, unit) + unit[:1] + "iB"
+			break
+		}
+	}
+	n, err := humanize.ParseBytes(q)
+	return int64(n), err
+}
+
+var invalidBucketChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// bucketName returns a valid MinIO bucket name for name.
+func bucketName(name string) string {
+	b := strings.Trim(invalidBucketChars.ReplaceAllString(strings.ToLower(name), "-"), "-.")
+	if len(b) > 63 {
+		b = strings.Trim(b[:63], "-.")
+	}
+	for len(b) < 3 {
+		b += "-ns"
+	}
+	return b
+}
+
+func writePlan(name string, plan *migrationPlan) error {
+	b, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, b, 0600)
+}
+
+func loadPlan(name string) (*migrationPlan, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var plan migrationPlan
+	if err = json.Unmarshal(b, &plan); err != nil {
+		return nil, fmt.Errorf("invalid plan file %s: %w", name, err)
+	}
+	if len(plan.Namespaces) == 0 {
+		return nil, fmt.Errorf("no namespaces in plan file %s", name)
+	}
+	if err = plan.checkBuckets(); err != nil {
+		return nil, fmt.Errorf("invalid plan file %s: %w", name, err)
+	}
+	return &plan, nil
+}
+
+// forEachPlannedNamespace calls fn for each namespace of the plan file,
+// with the namespace URL, host header and HCP backend set for it and
+// --data-dir pointing to a directory of its own. A namespace failing does
+// not stop the others.
+func forEachPlannedNamespace(fn func(ns plannedNamespace) error) error {
+	plan, err := loadPlan(planFilePath)
+	if err != nil {
+		return err
+	}
+	baseDir := dirPath
+	defer func() {
+		dirPath = baseDir
+	}()
+	var failed int
+	for _, ns := range plan.Namespaces {
+		namespaceURL = ns.NamespaceURL
+		hostHeader = ns.HostHeader
+		dirPath = path.Join(baseDir, ns.Name)
+		if err := os.MkdirAll(dirPath, 0700); err != nil {
+			return err
+		}
+		hcp = &hcpBackend{
+			URL:        namespaceURL,
+			authToken:  authToken,
+			hostHeader: hostHeader,
+			Insecure:   hcp.Insecure,
+		}
+		logMsg(fmt.Sprintf("Namespace %s in %s", ns.Name, dirPath))
+		if err := fn(ns); err != nil {
+			console.Errorln(fmt.Errorf("namespace %s: %w", ns.Name, err))
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d namespaces failed", failed, len(plan.Namespaces))
+	}
+	return nil
+}
+
+// completeListing returns the path of the object listing of the last
+// listing run in --data-dir, which must be complete.
+func completeListing() (string, error) {
+	cp, err := loadListCheckpoint("")
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("no listing in %s, run list with --plan-file first", dirPath)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer cp.Close()
+	if !cp.complete {
+		return "", fmt.Errorf("listing %s is incomplete, rerun list with --resume", cp.listFile)
+	}
+	return path.Join(dirPath, cp.listFile), nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBucketName(t *testing.T) {
+	testCases := []struct {
+		name   string
+		bucket string
+	}{
+		{"finance", "finance"},
+		{"Finance_Records", "finance-records"},
+		{"prefix-Ns1", "prefix-ns1"},
+		{"-_ns_-", "ns-ns"},
+		{"a", "a-ns"},
+		{"..x..", "x-ns"},
+		{"ns with spaces", "ns-with-spaces"},
+		{strings.Repeat("a", 62) + "_b", strings.Repeat("a", 62)},
+		{strings.Repeat("b", 70), strings.Repeat("b", 63)},
+	}
+	for i, testCase := range testCases {
+		if bucket := bucketName(testCase.name); bucket != testCase.bucket {
+			t.Errorf("Test %d: bucketName(%q) = %q, want %q", i+1, testCase.name, bucket, testCase.bucket)
+		}
+	}
+}
+
+func TestPlanCheckBuckets(t *testing.T) {
+	testCases := []struct {
+		buckets []string
+		success bool
+	}{
+		{[]string{"a-ns"}, true},
+		{[]string{"a-ns", "b-ns", "c-ns"}, true},
+		{[]string{"a-ns", "b-ns", "a-ns"}, false},
+		{[]string{bucketName("Ns_1"), bucketName("ns-1")}, false},
+	}
+	for i, testCase := range testCases {
+		plan := &migrationPlan{}
+		for _, b := range testCase.buckets {
+			plan.Namespaces = append(plan.Namespaces, plannedNamespace{Name: b, Bucket: b})
+		}
+		err := plan.checkBuckets()
+		if err != nil && testCase.success {
+			t.Errorf("Test %d: unexpected error %v", i+1, err)
+		}
+		if err == nil && !testCase.success {
+			t.Errorf("Test %d: expected an error for buckets %v", i+1, testCase.buckets)
+		}
+	}
+}
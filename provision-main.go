@@ -0,0 +1,153 @@
+/*
+ * MinIO Client (C) 2021 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/minio/cli"
+	"github.com/minio/minio/pkg/console"
+	"github.com/minio/minio/pkg/madmin"
+)
+
+var provisionFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "namespace",
+		Usage: "name of the HCP namespace to provision the bucket of, in MINIO_BUCKET or named after the namespace",
+	},
+	planFileFlag,
+	cli.BoolFlag{
+		Name:  "object-lock",
+		Usage: "create buckets with object lock even if the namespace has no default retention",
+	},
+	cli.BoolFlag{
+		Name:  "fake",
+		Usage: "only print the changes to the buckets, without making them",
+	},
+}
+
+var provisionCmd = cli.Command{
+	Name:   "provision",
+	Usage:  "Create and configure MinIO buckets to match the settings of HCP namespaces",
+	Action: provisionAction,
+	Flags:  append(mapiFlags, provisionFlags...),
+	CustomHelpTemplate: `NAME:
+  {{.HelpName}} - {{.Usage}}
+
+USAGE:
+  {{.HelpName}} --auth-token --mapi-url --namespace | --plan-file [--tenant, --bucket-prefix, --object-lock, --fake]
+
+FLAGS:
+  {{range .VisibleFlags}}{{.}}
+  {{end}}
+
+Versioning, object lock with the default retention of the namespace and its hard quota are set on
+the bucket. Object lock can only be enabled when a bucket is created.
+
+EXAMPLES:
+1. Print the changes needed for bucket miniobucket to match HCP namespace "s3testbucket" of tenant "finance"
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio provision -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" \
+		--mapi-url "https://finance.hcp.example.com:9090" --namespace s3testbucket --fake
+
+2. Create and configure the buckets of all namespaces of a plan written by the namespaces command
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ hcp-to-minio provision --plan-file /tmp/data/namespaces_plan.json_finance.10-01-2021-09-30-00
+`,
+}
+
+// newAdminClient returns a MinIO admin client for the MinIO server of
+// the migration.
+func newAdminClient(cliCtx *cli.Context) (*madmin.AdminClient, error) {
+	target, err := url.Parse(os.Getenv(EnvMinIOEndpoint))
+	if err != nil {
+		return nil, err
+	}
+	adm, err := madmin.New(target.Host, os.Getenv(EnvMinIOAccessKey), os.Getenv(EnvMinIOSecretKey), target.Scheme == "https")
+	if err != nil {
+		return nil, err
+	}
+	if cliCtx.Bool("insecure") {
+		adm.SetCustomTransport(&http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				MinVersion:         tls.VersionTLS12,
+				InsecureSkipVerify: true,
+			},
+		})
+	}
+	return adm, nil
+}
+
+func provisionAction(cliCtx *cli.Context) error {
+	ctx := context.Background()
+	dryRun = cliCtx.Bool("fake")
+	planFilePath = cliCtx.String("plan-file")
+	var namespaces []plannedNamespace
+	if planFilePath != "" {
+		logFlag = cliCtx.Bool("log")
+		debugFlag = cliCtx.Bool("debug")
+		plan, err := loadPlan(planFilePath)
+		if err != nil {
+			console.Fatalln(err)
+		}
+		namespaces = plan.Namespaces
+	} else {
+		mapiURL, tenant := initMapi(cliCtx)
+		name := cliCtx.String("namespace")
+		if name == "" {
+			console.Fatalln(fmt.Errorf("--namespace or --plan-file required"))
+		}
+		ns, err := hcp.planNamespace(ctx, mapiURL, tenant, name, cliCtx.String("bucket-prefix"))
+		if err != nil {
+			return err
+		}
+		if bucket := os.Getenv(EnvMinIOBucket); bucket != "" {
+			ns.Bucket = bucket
+		}
+		namespaces = append(namespaces, ns)
+	}
+	if err := initMinioClient(cliCtx); err != nil {
+		console.Fatalln(err)
+	}
+	adm, err := newAdminClient(cliCtx)
+	if err != nil {
+		console.Fatalln(err)
+	}
+
+	var failed int
+	for _, ns := range namespaces {
+		if err := provisionNamespace(ctx, adm, ns, cliCtx.Bool("object-lock")); err != nil {
+			console.Errorln(err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("unable to provision %d of %d buckets", failed, len(namespaces))
+	}
+	return nil
+}
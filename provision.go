@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	miniogo "github.com/minio/minio-go/v7"
+	"github.com/minio/minio/pkg/madmin"
+)
+
+// bucketConfig is the configuration of a MinIO bucket, as found on MinIO
+// or as derived from the settings of an HCP namespace.
+type bucketConfig struct {
+	exists     bool
+	versioning bool
+	objectLock bool
+	// default retention, none if mode is empty
+	mode     miniogo.RetentionMode
+	validity uint
+	unit     miniogo.ValidityUnit
+	quota    uint64 // hard quota in bytes, none if 0
+}
+
+func (c bucketConfig) retention() string {
+	if c.mode == "" {
+		return "none"
+	}
+	return fmt.Sprintf("%s %d %s", c.mode, c.validity, c.unit)
+}
+
+func (c bucketConfig) quotaString() string {
+	if c.quota == 0 {
+		return "none"
+	}
+	return humanize.IBytes(c.quota)
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// retentionOffset matches an HCP retention offset such as A+1y6M.
+var retentionOffset = regexp.MustCompile(`^A\+((\d+)y)?((\d+)M)?((\d+)w)?((\d+)d)?((\d+)h)?((\d+)m)?((\d+)s)?$`)
+
+// desiredBucketConfig returns the configuration of the bucket of the HCP
+// namespace ns, with warnings about settings that MinIO cannot express.
+// Object lock is enabled if ns has a default retention or is in
+// compliance mode, or if forceLock is set.
+func desiredBucketConfig(ns plannedNamespace, forceLock bool) (bucketConfig, []string) {
+	want := bucketConfig{
+		exists:     true,
+		versioning: ns.Versioning,
+		objectLock: forceLock || ns.RetentionMode == "compliance",
+		quota:      uint64(ns.HardQuotaBytes),
+	}
+	var warnings []string
+	mode := miniogo.Governance
+	if ns.RetentionMode == "compliance" {
+		mode = miniogo.Compliance
+	}
+	switch r := ns.DefaultRetention; {
+	case r == "" || r == "0" || r == "-2":
+	case r == "-1":
+		want.objectLock = true
+		warnings = append(warnings, "default retention 'deletion prohibited' has no bucket default on MinIO, objects keep their own retention")
+	case retentionOffset.MatchString(r):
+		m := retentionOffset.FindStringSubmatch(r)
+		n := func(i int) uint {
+			v, _ := strconv.ParseUint(m[i], 10, 32)
+			return uint(v)
+		}
+		want.objectLock = true
+		want.mode = mode
+		if m[3] == "" && m[5] == "" && m[7] == "" && m[9] == "" && m[11] == "" && m[13] == "" {
+			want.validity, want.unit = n(2), miniogo.Years
+		} else {
+			days := n(2)*365 + n(4)*30 + n(6)*7 + n(8)
+			if n(10) > 0 || n(12) > 0 || n(14) > 0 {
+				days++
+			}
+			want.validity, want.unit = days, miniogo.Days
+		}
+	default:
+		want.objectLock = true
+		warnings = append(warnings, fmt.Sprintf("default retention %s has no bucket default on MinIO, objects keep their own retention", r))
+	}
+	if want.objectLock && !want.versioning {
+		want.versioning = true
+		warnings = append(warnings, "versioning is required by object lock and will be enabled")
+	}
+	if ns.VersionPruneDays > 0 {
+		warnings = append(warnings, fmt.Sprintf("version pruning after %d days is not configured, set up an ILM rule for noncurrent versions", ns.VersionPruneDays))
+	}
+	return want, warnings
+}
+
+// currentBucketConfig reads the configuration of bucket from MinIO.
+func currentBucketConfig(ctx context.Context, adm *madmin.AdminClient, bucket string) (bucketConfig, error) {
+	var cur bucketConfig
+	exists, err := minioClient.BucketExists(ctx, bucket)
+	if err != nil || !exists {
+		return cur, err
+	}
+	cur.exists = true
+	vcfg, err := minioClient.GetBucketVersioning(ctx, bucket)
+	if err != nil {
+		return cur, err
+	}
+	cur.versioning = vcfg.Status == "Enabled"
+	lock, mode, validity, unit, err := minioClient.GetObjectLockConfig(ctx, bucket)
+	if err != nil && miniogo.ToErrorResponse(err).Code != "ObjectLockConfigurationNotFoundError" {
+		return cur, err
+	}
+	cur.objectLock = lock == "Enabled"
+	if mode != nil && validity != nil && unit != nil {
+		cur.mode, cur.validity, cur.unit = *mode, *validity, *unit
+	}
+	q, err := adm.GetBucketQuota(ctx, bucket)
+	if err != nil {
+		// buckets without a quota have no quota configuration
+		if madmin.ToErrorResponse(err).Code == "XMinioAdminNoSuchQuotaConfiguration" {
+			return cur, nil
+		}
+		return cur, err
+	}
+	cur.quota = q.Quota
+	return cur, nil
+}
+
+// diffBucketConfig returns the changes to make bucket go from cur to
+// want.
+func diffBucketConfig(cur, want bucketConfig) (changes []string, err error) {
+	if !cur.exists {
+		create := "+ create bucket"
+		if want.objectLock {
+			create += " with object lock"
+		}
+		changes = append(changes, create)
+	} else if want.objectLock && !cur.objectLock {
+		return nil, fmt.Errorf("object lock can only be enabled when the bucket is created, remove the bucket or provision another one")
+	}
+	if cur.versioning != want.versioning {
+		if cur.exists && cur.versioning && !want.versioning {
+			changes = append(changes, "~ versioning: on -> suspended")
+		} else {
+			changes = append(changes, fmt.Sprintf("~ versioning: %s -> %s", onOff(cur.versioning), onOff(want.versioning)))
+		}
+	}
+	if cur.retention() != want.retention() {
+		changes = append(changes, fmt.Sprintf("~ default retention: %s -> %s", cur.retention(), want.retention()))
+	}
+	if cur.quota != want.quota {
+		changes = append(changes, fmt.Sprintf("~ quota: %s -> %s", cur.quotaString(), want.quotaString()))
+	}
+	return changes, nil
+}
+
+// provisionBucket configures bucket as in want.
+func provisionBucket(ctx context.Context, adm *madmin.AdminClient, bucket string, cur, want bucketConfig) error {
+	if !cur.exists {
+		if err := minioClient.MakeBucket(ctx, bucket, miniogo.MakeBucketOptions{ObjectLocking: want.objectLock}); err != nil {
+			return fmt.Errorf("unable to create bucket: %w", err)
+		}
+		// MinIO enables versioning along with object lock.
+		cur.versioning = want.objectLock
+	}
+	if cur.versioning != want.versioning {
+		cfg := miniogo.BucketVersioningConfiguration{Status: "Suspended"}
+		if want.versioning {
+			cfg.Status = "Enabled"
+		}
+		if err := minioClient.SetBucketVersioning(ctx, bucket, cfg); err != nil {
+			return fmt.Errorf("unable to set versioning: %w", err)
+		}
+	}
+	if want.objectLock && cur.retention() != want.retention() {
+		var err error
+		if want.mode == "" {
+			err = minioClient.SetObjectLockConfig(ctx, bucket, nil, nil, nil)
+		} else {
+			err = minioClient.SetObjectLockConfig(ctx, bucket, &want.mode, &want.validity, &want.unit)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to set default retention: %w", err)
+		}
+	}
+	if cur.quota != want.quota {
+		q := &madmin.BucketQuota{Quota: want.quota}
+		if want.quota > 0 {
+			q.Type = madmin.HardQuota
+		}
+		if err := adm.SetBucketQuota(ctx, bucket, q); err != nil {
+			return fmt.Errorf("unable to set quota: %w", err)
+		}
+	}
+	return nil
+}
+
+// provisionNamespace prints the changes needed for the bucket of the HCP
+// namespace ns to match its settings and, unless dryRun is set, makes
+// them.
+func provisionNamespace(ctx context.Context, adm *madmin.AdminClient, ns plannedNamespace, forceLock bool) error {
+	want, warnings := desiredBucketConfig(ns, forceLock)
+	cur, err := currentBucketConfig(ctx, adm, ns.Bucket)
+	if err != nil {
+		return fmt.Errorf("unable to read configuration of bucket %s: %w", ns.Bucket, err)
+	}
+	if cur.objectLock {
+		// object lock, and the versioning it needs, cannot be turned off
+		want.objectLock, want.versioning = true, true
+	}
+	changes, err := diffBucketConfig(cur, want)
+	if err != nil {
+		return fmt.Errorf("bucket %s: %w", ns.Bucket, err)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Bucket %s for namespace %s:\n", ns.Bucket, ns.Name)
+	for _, c := range changes {
+		fmt.Fprintf(&b, "  %s\n", c)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(&b, "  no changes\n")
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(&b, "  ! %s\n", w)
+	}
+	fmt.Print(b.String())
+	if dryRun || len(changes) == 0 {
+		return nil
+	}
+	return provisionBucket(ctx, adm, ns.Bucket, cur, want)
+}
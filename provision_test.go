@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	miniogo "github.com/minio/minio-go/v7"
+)
+
+func TestDiffBucketConfig(t *testing.T) {
+	locked := bucketConfig{
+		exists:     true,
+		versioning: true,
+		objectLock: true,
+		mode:       miniogo.Compliance,
+		validity:   7,
+		unit:       miniogo.Years,
+	}
+	testCases := []struct {
+		cur     bucketConfig
+		want    bucketConfig
+		changes []string
+		success bool
+	}{
+		// nothing to do
+		{bucketConfig{exists: true}, bucketConfig{}, nil, true},
+		{locked, locked, nil, true},
+		// new buckets
+		{bucketConfig{}, bucketConfig{}, []string{"+ create bucket"}, true},
+		{bucketConfig{}, bucketConfig{versioning: true, objectLock: true},
+			[]string{"+ create bucket with object lock", "~ versioning: off -> on"}, true},
+		{bucketConfig{}, bucketConfig{quota: 1 << 30},
+			[]string{"+ create bucket", "~ quota: none -> 1.0 GiB"}, true},
+		// existing buckets
+		{bucketConfig{exists: true, versioning: true}, bucketConfig{},
+			[]string{"~ versioning: on -> suspended"}, true},
+		{bucketConfig{exists: true}, bucketConfig{versioning: true},
+			[]string{"~ versioning: off -> on"}, true},
+		{bucketConfig{exists: true, versioning: true, objectLock: true}, locked,
+			[]string{"~ default retention: none -> COMPLIANCE 7 YEARS"}, true},
+		{bucketConfig{exists: true, quota: 2 << 30}, bucketConfig{},
+			[]string{"~ quota: 2.0 GiB -> none"}, true},
+		// object lock cannot be enabled on existing buckets
+		{bucketConfig{exists: true}, locked, nil, false},
+	}
+	for i, testCase := range testCases {
+		changes, err := diffBucketConfig(testCase.cur, testCase.want)
+		if err != nil && testCase.success {
+			t.Errorf("Test %d: unexpected error %v", i+1, err)
+			continue
+		}
+		if err == nil && !testCase.success {
+			t.Errorf("Test %d: expected an error, got changes %v", i+1, changes)
+			continue
+		}
+		if !reflect.DeepEqual(changes, testCase.changes) {
+			t.Errorf("Test %d: changes %q, want %q", i+1, changes, testCase.changes)
+		}
+	}
+}
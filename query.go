@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio/pkg/console"
+)
+
+// queryRequest is an object based request to the HCP metadata query API.
+type queryRequest struct {
+	XMLName xml.Name    `xml:"queryRequest"`
+	Object  objectQuery `xml:"object"`
+}
+
+// objectQuery pages through the results of the query from the last
+// result of the previous page, so that each page costs the same and
+// objects changing during the listing are neither skipped nor repeated.
+type objectQuery struct {
+	Query      string           `xml:"query"`
+	Count      int              `xml:"count"`
+	Verbose    bool             `xml:"verbose"`
+	LastResult *queryLastResult `xml:"lastResult,omitempty"`
+}
+
+// queryLastResult identifies the last object of the previous page.
+type queryLastResult struct {
+	URLName                string `xml:"urlName"`
+	ChangeTimeMilliseconds string `xml:"changeTimeMilliseconds"`
+	Version                string `xml:"version"`
+}
+
+// queryResult is a page of results of an object based query.
+type queryResult struct {
+	XMLName xml.Name      `xml:"queryResult"`
+	Objects []queryObject `xml:"resultSet>object"`
+	Status  struct {
+		Results int    `xml:"results,attr"`
+		Message string `xml:"message,attr"`
+		Code    string `xml:"code,attr"`
+	} `xml:"status"`
+}
+
+// queryObject is an object in the results of a verbose object based
+// query. Unlike in directory listings, urlName is the full URL of the
+// object. changeTimeMilliseconds is kept as sent for the lastResult of
+// the next page.
+type queryObject struct {
+	URLName                   string `xml:"urlName,attr"`
+	Utf8Name                  string `xml:"utf8Name,attr"`
+	EntryType                 string `xml:"type,attr"`
+	Size                      int64  `xml:"size,attr"`
+	HashScheme                string `xml:"hashScheme,attr"`
+	Hash                      string `xml:"hash,attr"`
+	Retention                 int64  `xml:"retention,attr"`
+	RetentionString           string `xml:"retentionString,attr"`
+	RetentionClass            string `xml:"retentionClass,attr"`
+	IngestTime                int64  `xml:"ingestTime,attr"`
+	IngestTimeString          string `xml:"ingestTimeString,attr"`
+	Hold                      bool   `xml:"hold,attr"`
+	Shred                     bool   `xml:"shred,attr"`
+	DPL                       string `xml:"dpl,attr"`
+	Index                     bool   `xml:"index,attr"`
+	CustomMetadata            bool   `xml:"customMetadata,attr"`
+	CustomMetadataAnnotations string `xml:"customMetadataAnnotation,attr"`
+	Version                   string `xml:"version,attr"`
+	Replicated                bool   `xml:"replicated,attr"`
+	ChangeTimeMilliseconds    string `xml:"changeTimeMilliseconds,attr"`
+	ChangeTimeString          string `xml:"changeTimeString,attr"`
+	Owner                     string `xml:"owner,attr"`
+	Domain                    string `xml:"domain,attr"`
+	HasACL                    bool   `xml:"hasAcl,attr"`
+}
+
+// entry converts a query result to the listing entry of the object.
+func (o queryObject) entry() (Entry, error) {
+	u, err := url.Parse(o.URLName)
+	if err != nil {
+		return Entry{}, err
+	}
+	changeTime, err := parseChangeTime(o.ChangeTimeMilliseconds)
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w of %s", err, o.URLName)
+	}
+	return Entry{
+		ObjectPath:                u.Path,
+		URLName:                   path.Base(u.EscapedPath()),
+		Utf8Name:                  path.Base(o.Utf8Name),
+		EntryType:                 o.EntryType,
+		Size:                      o.Size,
+		HashScheme:                o.HashScheme,
+		Hash:                      o.Hash,
+		Retention:                 o.Retention,
+		RetentionString:           o.RetentionString,
+		RetentionClass:            o.RetentionClass,
+		IngestTime:                o.IngestTime,
+		IngestTimeString:          o.IngestTimeString,
+		Hold:                      o.Hold,
+		Shred:                     o.Shred,
+		DPL:                       o.DPL,
+		Index:                     o.Index,
+		CustomMetadata:            o.CustomMetadata,
+		CustomMetadataAnnotations: o.CustomMetadataAnnotations,
+		Version:                   o.Version,
+		Replicated:                o.Replicated,
+		ChangeTimeMilliseconds:    changeTime,
+		ChangeTimeString:          o.ChangeTimeString,
+		Owner:                     o.Owner,
+		Domain:                    o.Domain,
+		HasACL:                    o.HasACL,
+	}, nil
+}
+
+// namespaceHost returns the namespace host name from --host-header.
+func namespaceHost() string {
+	host := hostHeader
+	if strings.HasPrefix(strings.ToLower(host), "host:") {
+		host = strings.TrimSpace(host[len("host:"):])
+	}
+	return host
+}
+
+// queryHost returns the host header for requests to the metadata query
+// API, which is served by the tenant rather than the namespace.
+func queryHost() string {
+	if queryHostHeader != "" {
+		return queryHostHeader
+	}
+	host := namespaceHost()
+	if i := strings.Index(host, "."); i >= 0 {
+		return host[i+1:]
+	}
+	return host
+}
+
+// queryNamespace returns the name of the namespace to query, in the
+// namespace.tenant form expected by the metadata query API.
+func queryNamespace() string {
+	if queryNamespaceName != "" {
+		return queryNamespaceName
+	}
+	host := namespaceHost()
+	labels := strings.SplitN(host, ".", 3)
+	if len(labels) < 2 {
+		return host
+	}
+	return labels[0] + "." + labels[1]
+}
+
+// queryExpression returns the query for all objects of the namespace
+// changed within the time range given by --since and --until.
+func queryExpression() string {
+	until := listUntil
+	if until.IsZero() {
+		until = time.Now()
+	}
+	var since int64
+	if !listSince.IsZero() {
+		since = listSince.UnixNano() / int64(time.Millisecond)
+	}
+	return fmt.Sprintf(`+namespace:"%s" +changeTimeMilliseconds:[%d TO %d]`, queryNamespace(), since, until.UnixNano()/int64(time.Millisecond))
+}
+
+// query sends one page of an object based query to HCP.
+func (hcp *hcpBackend) query(ctx context.Context, qr queryRequest) (*queryResult, error) {
+	u, err := url.Parse(namespaceURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/query"
+	if queryURL != "" {
+		if u, err = url.Parse(queryURL); err != nil {
+			return nil, err
+		}
+	}
+	body, err := xml.Marshal(qr)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", authToken)
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Accept", "application/xml")
+	req.Host = queryHost()
+	resp, err := hcp.Client().Do(req)
+	if debugFlag {
+		console.Println(trace(req, resp))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closeResponse(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHCPError(resp)
+	}
+	var res queryResult
+	if err = xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("error decoding query result: %w", err)
+	}
+	return &res, nil
+}
+
+// queryObjectList pages through the metadata query engine for all
+// objects of the namespace and writes them to an object listing.
+func (hcp *hcpBackend) queryObjectList(ctx context.Context) (err error) {
+	u, err := url.Parse(namespaceURL)
+	if err != nil {
+		return err
+	}
+	lw, err := newListingWriter(path.Clean(u.Path), "")
+	if err != nil {
+		return err
+	}
+	defer lw.Close()
+	defer func() {
+		if serr := lw.writeSummary("", err == nil); err == nil {
+			err = serr
+		}
+	}()
+
+	qr := queryRequest{
+		Object: objectQuery{
+			Query:   queryExpression(),
+			Count:   queryPageSize,
+			Verbose: true,
+		},
+	}
+	logMsg(fmt.Sprintf("Querying HCP for %s", qr.Object.Query))
+	var count int
+	for {
+		var res *queryResult
+		err := withRetry(ctx, listRetries, "metadata query", func() (err error) {
+			res, err = hcp.query(ctx, qr)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("metadata query failed after %d objects, listing in %s is incomplete: %w", count, lw.name, err)
+		}
+		for _, o := range res.Objects {
+			entry, err := o.entry()
+			if err != nil {
+				return err
+			}
+			if !filter.matchEntry(entry) {
+				continue
+			}
+			if listVersions {
+				err = withRetry(ctx, listRetries, "version listing of "+entry.ObjectPath, func() (err error) {
+					entry.Versions, err = hcp.ListVersions(ctx, entry.ObjectPath)
+					return err
+				})
+				if err != nil {
+					return fmt.Errorf("listing in %s is incomplete: %w", lw.name, err)
+				}
+			}
+			if err = lw.writeEntry(entry); err != nil {
+				return err
+			}
+			count++
+		}
+		if res.Status.Code == "COMPLETE" || len(res.Objects) == 0 {
+			break
+		}
+		last := res.Objects[len(res.Objects)-1]
+		qr.Object.LastResult = &queryLastResult{
+			URLName:                last.URLName,
+			ChangeTimeMilliseconds: last.ChangeTimeMilliseconds,
+			Version:                last.Version,
+		}
+		logDMsg(fmt.Sprintf("queried %d objects", count), nil)
+	}
+	logMsg(fmt.Sprintf("Listed %d objects to %s", count, lw.name))
+	if err = lw.Flush(); err != nil {
+		return err
+	}
+	// Journal the listing as complete for migrate --plan-file.
+	cp, err := newListCheckpoint("", lw.name, listFormat)
+	if err != nil {
+		return err
+	}
+	err = cp.markComplete()
+	if cerr := cp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || listShards <= 1 {
+		return err
+	}
+	return shardListing(lw.name, "")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryObjectListPages(t *testing.T) {
+	// objects of the query, in the order HCP returns them
+	objects := []queryObject{
+		{URLName: "https://ns.tenant.hcp.example.com/rest/q/o0", EntryType: "object", Size: 1, ChangeTimeMilliseconds: "1600000000000.00", Version: "10"},
+		{URLName: "https://ns.tenant.hcp.example.com/rest/q/o1", EntryType: "object", Size: 2, ChangeTimeMilliseconds: "1600000000001.00", Version: "11"},
+		{URLName: "https://ns.tenant.hcp.example.com/rest/q/o2", EntryType: "object", Size: 3, ChangeTimeMilliseconds: "1600000000001.00", Version: "12"},
+	}
+	testCases := []struct {
+		pageSize int
+		pages    int
+	}{
+		{3, 1},
+		{2, 2},
+		{1, 3},
+	}
+	for i, testCase := range testCases {
+		func() {
+			defer func(n, d, f string, s int, b *hcpBackend) {
+				namespaceURL, dirPath, listFormat, queryPageSize, hcp = n, d, f, s, b
+			}(namespaceURL, dirPath, listFormat, queryPageSize, hcp)
+			dirPath = t.TempDir()
+			listFormat = listFormatText
+			queryPageSize = testCase.pageSize
+
+			var requests []queryRequest
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var qr queryRequest
+				if err := xml.NewDecoder(r.Body).Decode(&qr); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				requests = append(requests, qr)
+				// the page after the last result of the previous one
+				start := 0
+				if lr := qr.Object.LastResult; lr != nil {
+					for start < len(objects) {
+						o := objects[start]
+						start++
+						if o.URLName == lr.URLName && o.ChangeTimeMilliseconds == lr.ChangeTimeMilliseconds && o.Version == lr.Version {
+							break
+						}
+					}
+				}
+				end := start + qr.Object.Count
+				code := "INCOMPLETE"
+				if end >= len(objects) {
+					end, code = len(objects), "COMPLETE"
+				}
+				var res strings.Builder
+				res.WriteString(`<queryResult><query start="0" end="1600000000002"/><resultSet>`)
+				for _, o := range objects[start:end] {
+					fmt.Fprintf(&res, `<object urlName="%s" utf8Name="%s" type="%s" size="%d" changeTimeMilliseconds="%s" version="%s" operation="CREATED"/>`,
+						o.URLName, o.URLName, o.EntryType, o.Size, o.ChangeTimeMilliseconds, o.Version)
+				}
+				fmt.Fprintf(&res, `</resultSet><status results="%d" message="" code="%s"/></queryResult>`, end-start, code)
+				w.Write([]byte(res.String()))
+			}))
+			defer srv.Close()
+			namespaceURL = srv.URL + "/rest"
+			hcp = &hcpBackend{URL: namespaceURL, client: srv.Client()}
+
+			if err := hcp.queryObjectList(context.Background()); err != nil {
+				t.Errorf("Test %d: unexpected error %v", i+1, err)
+				return
+			}
+			if len(requests) != testCase.pages {
+				t.Errorf("Test %d: %d pages queried, want %d", i+1, len(requests), testCase.pages)
+			}
+			for j, qr := range requests {
+				if j == 0 {
+					if qr.Object.LastResult != nil {
+						t.Errorf("Test %d: first page queried after %+v", i+1, *qr.Object.LastResult)
+					}
+					continue
+				}
+				last := objects[j*testCase.pageSize-1]
+				want := queryLastResult{URLName: last.URLName, ChangeTimeMilliseconds: last.ChangeTimeMilliseconds, Version: last.Version}
+				if qr.Object.LastResult == nil || *qr.Object.LastResult != want {
+					t.Errorf("Test %d: page %d queried after %+v, want %+v", i+1, j+1, qr.Object.LastResult, want)
+				}
+			}
+			names, err := filepath.Glob(path.Join(dirPath, objListFile+"*"))
+			if err != nil || len(names) != 1 {
+				t.Fatalf("Test %d: listings %v, %v", i+1, names, err)
+			}
+			b, err := ioutil.ReadFile(names[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if listed, want := strings.Fields(string(b)), []string{"/rest/q/o0", "/rest/q/o1", "/rest/q/o2"}; !reflect.DeepEqual(listed, want) {
+				t.Errorf("Test %d: listed %v, want %v", i+1, listed, want)
+			}
+		}()
+	}
+}
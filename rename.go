@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/minio/cli"
+	miniogo "github.com/minio/minio-go/v7"
+)
+
+const renameMapFile = "rename_map.txt"
+
+var renameFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "rename-from-annotation",
+		Usage: "name of the HCP annotation holding a report document to derive the MinIO object name and metadata from",
+	},
+}
+
+// renameAnnotation is the annotation set by --rename-from-annotation.
+var renameAnnotation string
+
+// renameMap records the MinIO object name each HCP object is migrated to
+// when renamed, one "<hcp path>\t<object name>" line per object.
+type renameMap struct {
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	name string
+}
+
+var renames *renameMap
+
+func newRenameMap() (*renameMap, error) {
+	name := getFileName(renameMapFile, "")
+	f, err := os.OpenFile(path.Join(dirPath, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &renameMap{f: f, w: bufio.NewWriter(f), name: name}, nil
+}
+
+func (m *renameMap) add(object, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.w, "%s\t%s\n", object, key)
+	return err
+}
+
+func (m *renameMap) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.w.Flush(); err != nil {
+		m.f.Close()
+		return err
+	}
+	return m.f.Close()
+}
+
+// renameObject sets the MinIO object name and user metadata of oi from
+// the report document in the annotation renameAnnotation of object.
+func renameObject(ctx context.Context, object string, oi *miniogo.ObjectInfo) error {
+	b, err := hcp.GetAnnotation(ctx, object, renameAnnotation)
+	if err != nil {
+		return fmt.Errorf("unable to get annotation %s: %w", renameAnnotation, err)
+	}
+	doc, err := parseDocument(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("invalid document in annotation %s: %w", renameAnnotation, err)
+	}
+	oi.Key = doc.getMinIOObjectName()
+	if oi.UserMetadata == nil {
+		oi.UserMetadata = make(miniogo.StringMap)
+	}
+	for k, v := range doc.getObjectMetadata() {
+		oi.UserMetadata[k] = v
+	}
+	return nil
+}
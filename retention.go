@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/cli"
+	miniogo "github.com/minio/minio-go/v7"
+)
+
+// HCP headers of the retention of an object.
+const (
+	hcpRetentionHeader      = "X-HCP-Retention"
+	hcpRetentionClassHeader = "X-HCP-RetentionClass"
+	hcpRetentionHoldHeader  = "X-HCP-RetentionHold"
+)
+
+// Special HCP retention values, any other is the end of retention in
+// seconds since the epoch.
+const (
+	retentionDeletionAllowed    = 0
+	retentionDeletionProhibited = -1
+	retentionInitialUnspecified = -2
+)
+
+var retentionFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "retention-mode",
+		Usage: "object lock mode of objects under HCP retention, GOVERNANCE or COMPLIANCE",
+		Value: string(miniogo.Governance),
+	},
+	cli.StringSliceFlag{
+		Name:  "retention-class-mode",
+		Usage: "object lock mode of objects of an HCP retention class as CLASS=MODE, overriding --retention-mode. May be repeated",
+	},
+}
+
+var (
+	retentionMode       = miniogo.Governance
+	retentionClassModes = map[string]miniogo.RetentionMode{}
+)
+
+func parseRetentionMode(s string) (miniogo.RetentionMode, error) {
+	mode := miniogo.RetentionMode(strings.ToUpper(s))
+	if !mode.IsValid() {
+		return "", fmt.Errorf("invalid retention mode %s, must be GOVERNANCE or COMPLIANCE", s)
+	}
+	return mode, nil
+}
+
+// parseRetentionFlags sets retentionMode and retentionClassModes from
+// --retention-mode and --retention-class-mode.
+func parseRetentionFlags(ctx *cli.Context) (err error) {
+	if retentionMode, err = parseRetentionMode(ctx.String("retention-mode")); err != nil {
+		return err
+	}
+	for _, m := range ctx.StringSlice("retention-class-mode") {
+		kv := strings.SplitN(m, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid --retention-class-mode %s, must be CLASS=MODE", m)
+		}
+		mode, err := parseRetentionMode(kv[1])
+		if err != nil {
+			return fmt.Errorf("--retention-class-mode %s: %w", m, err)
+		}
+		retentionClassModes[kv[0]] = mode
+	}
+	return nil
+}
+
+// hcpRetention is the retention setting and legal hold of an HCP object.
+type hcpRetention struct {
+	value int64 // end of retention in seconds since the epoch or a special value
+	class string
+	hold  bool
+}
+
+func entryRetention(e Entry) hcpRetention {
+	return hcpRetention{value: e.Retention, class: e.RetentionClass, hold: e.Hold}
+}
+
+// headerRetention returns the retention HCP reports in the headers of a
+// GET on an object.
+func headerRetention(h http.Header) (r hcpRetention, err error) {
+	if v := h.Get(hcpRetentionHeader); v != "" {
+		if r.value, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return r, fmt.Errorf("invalid %s header %s", hcpRetentionHeader, v)
+		}
+	}
+	r.class = h.Get(hcpRetentionClassHeader)
+	r.hold = strings.EqualFold(h.Get(hcpRetentionHoldHeader), "true")
+	return r, nil
+}
+
+// mode returns the object lock mode of r, from the mode of its retention
+// class if one is set.
+func (r hcpRetention) mode() miniogo.RetentionMode {
+	if mode, ok := retentionClassModes[strings.TrimPrefix(r.class, "C+")]; ok {
+		return mode
+	}
+	return retentionMode
+}
+
+// check returns an error if r has no equivalent in MinIO object lock.
+// Objects with such a retention are refused rather than uploaded without
+// it.
+func (r hcpRetention) check() error {
+	switch {
+	case r.value == retentionDeletionProhibited:
+		return fmt.Errorf("retention 'Deletion Prohibited' cannot be represented on MinIO, object refused")
+	case r.value == retentionInitialUnspecified:
+		return fmt.Errorf("retention 'Initial Unspecified' cannot be represented on MinIO, object refused")
+	case r.value < 0:
+		return fmt.Errorf("unknown retention value %d, object refused", r.value)
+	}
+	return nil
+}
+
+// apply sets the object lock of opts to r. A retention period that has
+// already ended is left out.
+func (r hcpRetention) apply(opts *miniogo.PutObjectOptions) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	if r.value != retentionDeletionAllowed {
+		if until := time.Unix(r.value, 0).UTC(); until.After(time.Now()) {
+			opts.Mode = r.mode()
+			opts.RetainUntilDate = until
+		}
+	}
+	if r.hold {
+		opts.LegalHold = miniogo.LegalHoldEnabled
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+/*
+ * MinIO Client (C) 2021 MinIO, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/minio/cli"
+	"github.com/minio/minio/pkg/console"
+)
+
+var retryFailedFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:  "fails-file",
+		Usage: "migration_fails.txt of a migration to retry the objects of, may be repeated. Defaults to the latest in --data-dir",
+	},
+	cli.StringSliceFlag{
+		Name:  "error-class",
+		Usage: "only retry objects that failed with an error of this class, one of transient, not-found, denied, retention, verify or other. May be repeated",
+	},
+	cli.BoolFlag{
+		Name:  "fake",
+		Usage: "perform a fake migration",
+	},
+	cli.IntFlag{
+		Name:  "retries",
+		Usage: "number of times to retry migrating an object after a transient HCP or MinIO failure",
+		Value: 5,
+	},
+	cli.BoolFlag{
+		Name:  "versions",
+		Usage: "migrate every version of each object, oldest first, into a versioned bucket",
+	},
+	planFileFlag,
+}
+
+var retryFailedCmd = cli.Command{
+	Name:   "retry-failed",
+	Usage:  "Migrate again the HCP objects that failed to migrate",
+	Action: retryFailedAction,
+	Flags:  append(append(allFlags, retryFailedFlags...), migrateCommonFlags...),
+	CustomHelpTemplate: `NAME:
+	{{.HelpName}} - {{.Usage}}
+
+USAGE:
+	{{.HelpName}} --auth-token --namespace-url --host-header --data-dir [--fails-file, --error-class, --fake, --retries, --versions, --plan-file] [MIGRATE FLAGS]
+
+FLAGS:
+   {{range .VisibleFlags}}{{.}}
+   {{end}}
+
+Objects are migrated again as listed in the listing of the migration journal in --data-dir, or by
+their path if it has none, with the same flags as migrate, pass those of the migration that failed.
+The objects that still fail, and those of other error classes than --error-class, are recorded in a
+new migration_fails.txt in --data-dir, the latest one for the next retry-failed. The migration
+journal in --data-dir is updated if there is one. With --plan-file, namespaces without a
+migration_fails.txt are skipped.
+
+EXAMPLES:
+1. Migrate again the objects of the latest migration_fails.txt in /tmp/data
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio retry-failed -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+			--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data"
+
+2. Migrate again, with their content verified, the objects of two fails files that failed with a transient error
+   $ export MINIO_ENDPOINT=https://minio:9000
+   $ export MINIO_ACCESS_KEY=minio
+   $ export MINIO_SECRET_KEY=minio123
+   $ export MINIO_BUCKET=miniobucket
+   $ hcp-to-minio retry-failed -a "HCP bXl1c2Vy:3f3c6784e97531774380db177774ac8d" --host-header "HOST:s3testbucket.tenant.hcp.example.com \
+			--namespace-url "https://hcp-vip.example.com/rest" --data-dir "/tmp/data" --verify --error-class transient \
+			--fails-file /tmp/data/migration_fails.txt.10-01-2021-09-30-00 --fails-file /tmp/data/migration_fails.txt.10-02-2021-09-30-00
+`,
+}
+
+func retryFailedAction(cliCtx *cli.Context) error {
+	checkArgsAndInit(cliCtx)
+	ctx := context.Background()
+	if err := initMinioClient(cliCtx); err != nil {
+		cli.ShowCommandHelp(cliCtx, cliCtx.Command.Name)
+		console.Fatalln(err)
+	}
+	if minioBucket == "" && planFilePath == "" {
+		console.Fatalln(fmt.Errorf("MINIO_BUCKET needs to be set"))
+	}
+	classes, err := parseFailureClasses(cliCtx.StringSlice("error-class"))
+	if err != nil {
+		console.Fatalln(err)
+	}
+	parseMigrateSettings(cliCtx)
+	failsFiles := cliCtx.StringSlice("fails-file")
+	if planFilePath != "" {
+		if len(failsFiles) > 0 {
+			console.Fatalln(fmt.Errorf("--fails-file is not supported with --plan-file"))
+		}
+		return forEachPlannedNamespace(func(ns plannedNamespace) error {
+			minioBucket = ns.Bucket
+			err := retryFailed(ctx, nil, classes)
+			if errors.Is(err, errNoFailsFile) {
+				logMsg(fmt.Sprintf("Nothing to retry in namespace %s: %s", ns.Name, err))
+				return nil
+			}
+			return err
+		})
+	}
+	return retryFailed(ctx, failsFiles, classes)
+}
+
+// retryFailed migrates again the objects of the fails files in names, or
+// of the latest fails file in --data-dir if there are none, that failed
+// with an error of one of classes, or of any if classes is empty. The
+// objects are migrated again as listed in the listing of the migration
+// journal, or by their path if they are not found in it.
+func retryFailed(ctx context.Context, names []string, classes map[string]bool) error {
+	if len(names) == 0 {
+		name, err := latestFailsFile(dirPath)
+		if err != nil {
+			return err
+		}
+		names = []string{name}
+	}
+	failed, err := readFailsFiles(names)
+	if err != nil {
+		return err
+	}
+	journal, err := loadMigrationJournal()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var (
+		retry   []string
+		carried []migrationErr
+	)
+	paths := make(map[string]bool)
+	counts := make(map[string]int)
+	for _, fo := range failed {
+		counts[fo.class]++
+		if len(classes) > 0 && !classes[fo.class] {
+			carried = append(carried, migrationErr{object: fo.object, err: errors.New(fo.msg)})
+			continue
+		}
+		retry = append(retry, fo.object)
+		paths[fo.object] = true
+	}
+	var lines map[string]string
+	if journal != nil && len(retry) > 0 {
+		if lines, err = listedEntries(journal.listFile, paths); err != nil {
+			logMsg(fmt.Sprintf("Retrying the failed objects by their path only: %s", err))
+		}
+	}
+	var listing strings.Builder
+	for _, object := range retry {
+		if line, ok := lines[object]; ok {
+			listing.WriteString(line + "\n")
+			continue
+		}
+		listing.WriteString(object + "\n")
+	}
+	var summary []string
+	for _, c := range failureClassNames {
+		if counts[c] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[c], c))
+		}
+	}
+	logMsg(fmt.Sprintf("Retrying %d of %d failed objects in %s (%s)", len(retry), len(failed), strings.Join(names, ", "), strings.Join(summary, ", ")))
+
+	return migrateEntries(ctx, strings.NewReader(listing.String()), strings.Join(names, ", "), false, 0, journal, carried)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+const (
+	shardByHash = "hash"
+	shardBySize = "size"
+)
+
+// shardAssigner picks the shard of each object of a listing. By hash,
+// objects are spread on the hash of their path. By size, the sizes of all
+// objects are collected first and balanced largest first, each object
+// going to the shard holding the fewest bytes so far.
+type shardAssigner struct {
+	by      string
+	objects []int64
+	bytes   []int64
+	// sizes and shards of the objects in listing order, by size
+	sizes  []int64
+	shards []int32
+}
+
+func newShardAssigner(by string, n int) *shardAssigner {
+	return &shardAssigner{
+		by:      by,
+		objects: make([]int64, n),
+		bytes:   make([]int64, n),
+	}
+}
+
+// hashShard returns the shard of entry by the hash of its path.
+func (a *shardAssigner) hashShard(entry Entry) int {
+	h := fnv.New32a()
+	h.Write([]byte(entry.ObjectPath))
+	return int(h.Sum32() % uint32(len(a.objects)))
+}
+
+// add counts entry, the next object of the listing, in the totals of its
+// shard. By size it is only counted by balance.
+func (a *shardAssigner) add(entry Entry) {
+	if a.by == shardByHash {
+		i := a.hashShard(entry)
+		a.objects[i]++
+		a.bytes[i] += entry.totalSize()
+		return
+	}
+	a.sizes = append(a.sizes, entry.totalSize())
+}
+
+// balance assigns the objects added by size to shards, largest first.
+func (a *shardAssigner) balance() {
+	if a.by == shardByHash {
+		return
+	}
+	order := make([]int32, len(a.sizes))
+	for k := range order {
+		order[k] = int32(k)
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return a.sizes[order[i]] > a.sizes[order[j]]
+	})
+	a.shards = make([]int32, len(a.sizes))
+	for _, k := range order {
+		i := 0
+		for j := range a.bytes {
+			if a.bytes[j] < a.bytes[i] || (a.bytes[j] == a.bytes[i] && a.objects[j] < a.objects[i]) {
+				i = j
+			}
+		}
+		a.shards[k] = int32(i)
+		a.objects[i]++
+		a.bytes[i] += a.sizes[k]
+	}
+	a.sizes = nil
+}
+
+// shard returns the shard of entry, the k-th object of the listing.
+func (a *shardAssigner) shard(k int, entry Entry) int {
+	if a.by == shardByHash {
+		return a.hashShard(entry)
+	}
+	return int(a.shards[k])
+}
+
+// shardFileName returns the name of shard i of the listing of prefix.
+func shardFileName(listFile, prefix string, i, n int) string {
+	name := objListFile
+	if strings.HasPrefix(path.Base(listFile), objListJSONFile) {
+		name = objListJSONFile
+	}
+	return getFileName(name, fmt.Sprintf("%s/shard_%d_of_%d", prefix, i+1, n))
+}
+
+// forEachListed calls fn with each entry of the object listing listFile.
+func forEachListed(listFile string, fn func(line string, entry Entry) error) error {
+	f, err := os.Open(path.Join(dirPath, listFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry, err := parseListingLine(line)
+		if err != nil {
+			return fmt.Errorf("malformed entry in %s: %w", listFile, err)
+		}
+		if err = fn(line, entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// shardListing splits the complete object listing listFile of prefix into
+// listShards manifest files, one for each migrate host. Each starts with
+// a header line recording its shard id and totals, which migrate skips.
+func shardListing(listFile, prefix string) error {
+	n := listShards
+	// The first pass only assigns, so that the totals can be written
+	// at the top of each shard.
+	a := newShardAssigner(listShardBy, n)
+	if err := forEachListed(listFile, func(_ string, entry Entry) error {
+		a.add(entry)
+		return nil
+	}); err != nil {
+		return err
+	}
+	a.balance()
+
+	files := make([]*os.File, n)
+	writers := make([]*bufio.Writer, n)
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	for i := range files {
+		name := shardFileName(listFile, prefix, i, n)
+		f, err := os.OpenFile(path.Join(dirPath, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		files[i] = f
+		writers[i] = bufio.NewWriter(f)
+		fmt.Fprintf(writers[i], "# shard %d/%d listing=%s objects=%d bytes=%d\n", i+1, n, listFile, a.objects[i], a.bytes[i])
+		logMsg(fmt.Sprintf("Shard %d of %d: %s objects, %s in %s", i+1, n,
+			humanize.Comma(a.objects[i]), humanize.IBytes(uint64(a.bytes[i])), name))
+	}
+
+	var k int
+	if err := forEachListed(listFile, func(line string, entry Entry) error {
+		_, err := writers[a.shard(k, entry)].WriteString(line + "\n")
+		k++
+		return err
+	}); err != nil {
+		return err
+	}
+	for i, w := range writers {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if err := files[i].Close(); err != nil {
+			return err
+		}
+		files[i] = nil
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+const (
+	listSummaryFile     = "list_summary.txt"
+	listSummaryJSONFile = "list_summary.json"
+	largestObjectsCount = 10
+)
+
+// sizeBuckets are the buckets of the object size histogram, named as in
+// the MinIO data usage report.
+var sizeBuckets = []struct {
+	name string
+	max  int64 // exclusive upper bound
+}{
+	{"LESS_THAN_1024_B", humanize.KiByte},
+	{"BETWEEN_1024_B_AND_1_MB", humanize.MiByte},
+	{"BETWEEN_1_MB_AND_10_MB", 10 * humanize.MiByte},
+	{"BETWEEN_10_MB_AND_64_MB", 64 * humanize.MiByte},
+	{"BETWEEN_64_MB_AND_128_MB", 128 * humanize.MiByte},
+	{"BETWEEN_128_MB_AND_512_MB", 512 * humanize.MiByte},
+	{"GREATER_THAN_512_MB", 1<<63 - 1},
+}
+
+// prefixStats holds the totals of a top level prefix.
+type prefixStats struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// largestObject is an entry of the largest objects of the listing.
+type largestObject struct {
+	Object string `json:"object"`
+	Size   int64  `json:"size"`
+}
+
+// largestObjects is a min heap of the largest objects seen so far.
+type largestObjects []largestObject
+
+func (h largestObjects) Len() int            { return len(h) }
+func (h largestObjects) Less(i, j int) bool  { return h[i].Size < h[j].Size }
+func (h largestObjects) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *largestObjects) Push(x interface{}) { *h = append(*h, x.(largestObject)) }
+func (h *largestObjects) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// listingStats is the inventory of a listing run, written next to the
+// object listing for capacity and time planning.
+type listingStats struct {
+	Root     string `json:"root"`
+	Listing  string `json:"listing"`
+	Complete bool   `json:"complete"`
+	Objects  int64  `json:"objects"`
+	Bytes    int64  `json:"bytes"`
+	Versions int64  `json:"versions,omitempty"`
+	// UnknownSize counts objects carried over from a resumed text listing,
+	// whose size is not recorded.
+	UnknownSize   int64                   `json:"unknownSize,omitempty"`
+	SizeHistogram map[string]int64        `json:"sizeHistogram"`
+	Prefixes      map[string]*prefixStats `json:"prefixes"`
+	Largest       largestObjects          `json:"largest"`
+	Dropped       map[string]int64        `json:"dropped,omitempty"`
+}
+
+func newListingStats(root string) *listingStats {
+	return &listingStats{
+		Root:          root,
+		SizeHistogram: make(map[string]int64),
+		Prefixes:      make(map[string]*prefixStats),
+		Dropped:       make(map[string]int64),
+	}
+}
+
+// addObject adds an object of the listing to the inventory.
+func (s *listingStats) addObject(entry Entry) {
+	s.Objects++
+	prefix := strings.TrimPrefix(entry.ObjectPath, s.Root+"/")
+	if i := strings.Index(prefix, "/"); i >= 0 {
+		prefix = prefix[:i]
+	} else {
+		prefix = "/"
+	}
+	ps, ok := s.Prefixes[prefix]
+	if !ok {
+		ps = &prefixStats{}
+		s.Prefixes[prefix] = ps
+	}
+	ps.Objects++
+	if entry.EntryType == "" {
+		s.UnknownSize++
+		return
+	}
+
+	size := entry.totalSize()
+	s.Versions += int64(len(entry.Versions))
+	s.Bytes += size
+	ps.Bytes += size
+	for _, b := range sizeBuckets {
+		if entry.Size < b.max {
+			s.SizeHistogram[b.name]++
+			break
+		}
+	}
+	if len(s.Largest) < largestObjectsCount {
+		heap.Push(&s.Largest, largestObject{Object: entry.ObjectPath, Size: entry.Size})
+	} else if entry.Size > s.Largest[0].Size {
+		s.Largest[0] = largestObject{Object: entry.ObjectPath, Size: entry.Size}
+		heap.Fix(&s.Largest, 0)
+	}
+}
+
+// addDropped counts an entry left out of the listing because of its type.
+func (s *listingStats) addDropped(entry Entry) {
+	s.Dropped[entry.EntryType]++
+}
+
+// write writes the inventory to --data-dir as text and as JSON and
+// returns the name of the text file.
+func (s *listingStats) write(prefix string) (string, error) {
+	sort.Sort(sort.Reverse(s.Largest))
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := getFileName(listSummaryFile, prefix)
+	jsonName := strings.Replace(name, listSummaryFile, listSummaryJSONFile, 1)
+	if err = ioutil.WriteFile(path.Join(dirPath, jsonName), b, 0600); err != nil {
+		return "", err
+	}
+	return name, ioutil.WriteFile(path.Join(dirPath, name), []byte(s.String()), 0600)
+}
+
+func (s *listingStats) String() string {
+	var b strings.Builder
+	state := "complete"
+	if !s.Complete {
+		state = "incomplete"
+	}
+	fmt.Fprintf(&b, "Listing of %s in %s (%s)\n\n", s.Root, s.Listing, state)
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Objects:\t%s\n", humanize.Comma(s.Objects))
+	fmt.Fprintf(w, "Bytes:\t%s\t(%s)\n", humanize.Comma(s.Bytes), humanize.IBytes(uint64(s.Bytes)))
+	if s.Versions > 0 {
+		fmt.Fprintf(w, "Versions:\t%s\n", humanize.Comma(s.Versions))
+	}
+	if s.UnknownSize > 0 {
+		fmt.Fprintf(w, "Objects of unknown size:\t%s\n", humanize.Comma(s.UnknownSize))
+	}
+
+	fmt.Fprintf(w, "\nSize histogram:\n")
+	for _, bucket := range sizeBuckets {
+		fmt.Fprintf(w, "  %s\t%s\n", bucket.name, humanize.Comma(s.SizeHistogram[bucket.name]))
+	}
+
+	fmt.Fprintf(w, "\nTop level prefixes:\n")
+	prefixes := make([]string, 0, len(s.Prefixes))
+	for prefix := range s.Prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		ps := s.Prefixes[prefix]
+		fmt.Fprintf(w, "  %s\t%s objects\t%s\n", prefix, humanize.Comma(ps.Objects), humanize.IBytes(uint64(ps.Bytes)))
+	}
+
+	fmt.Fprintf(w, "\nLargest objects:\n")
+	for _, o := range s.Largest {
+		fmt.Fprintf(w, "  %s\t%s\n", humanize.IBytes(uint64(o.Size)), o.Object)
+	}
+
+	if len(s.Dropped) > 0 {
+		fmt.Fprintf(w, "\nDropped entries by type:\n")
+		for typ, n := range s.Dropped {
+			fmt.Fprintf(w, "  %s\t%s\n", typ, humanize.Comma(n))
+		}
+	}
+	w.Flush()
+	return b.String()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/minio/cli"
+	miniogo "github.com/minio/minio-go/v7"
+	"github.com/minio/minio/pkg/console"
+)
+
+const (
+	symlinkSkip   = "skip"
+	symlinkFollow = "follow"
+	symlinkLink   = "link"
+	symlinkReport = "report"
+
+	symlinkReportFile = "symlink_report.txt"
+
+	// symlinkTargetMeta is the user metadata naming the target of a link
+	// migrated with --symlinks link.
+	symlinkTargetMeta = "hcp-symlink-target"
+)
+
+var symlinkFlags = []cli.Flag{
+	cli.StringFlag{
+		Name: "symlinks",
+		Usage: "how to handle HCP symbolic links, 'skip' to leave them out, 'follow' to copy the content of the target, " +
+			"'link' to create an empty object naming the target in its metadata or 'report' to list them in a separate file",
+		Value: symlinkSkip,
+	},
+}
+
+var symlinkPolicy = symlinkSkip
+
+// parseSymlinkFlag sets symlinkPolicy from --symlinks.
+func parseSymlinkFlag(ctx *cli.Context) error {
+	symlinkPolicy = ctx.String("symlinks")
+	switch symlinkPolicy {
+	case symlinkSkip, symlinkFollow, symlinkLink, symlinkReport:
+		return nil
+	}
+	return fmt.Errorf("--symlinks must be one of %s, %s, %s or %s", symlinkSkip, symlinkFollow, symlinkLink, symlinkReport)
+}
+
+// ReadSymlink returns the path of the target of the HCP symbolic link at
+// object, as reported by HCP in the X-HCP-SymlinkTarget header of a HEAD
+// request on the link. Relative targets are resolved against the
+// directory of the link.
+func (hcp *hcpBackend) ReadSymlink(ctx context.Context, object string) (string, error) {
+	u, err := url.Parse(namespaceURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = object
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", authToken)
+	req.Host = hostHeader
+	resp, err := hcp.Client().Do(req)
+	if debugFlag {
+		console.Println(trace(req, resp))
+	}
+	if err != nil {
+		return "", err
+	}
+	defer closeResponse(resp)
+	if resp.StatusCode != http.StatusOK {
+		return "", newHCPError(resp)
+	}
+	target := resp.Header.Get("X-HCP-SymlinkTarget")
+	if target == "" {
+		return "", fmt.Errorf("no symbolic link target reported for %s", object)
+	}
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(object), target)
+	}
+	return path.Clean(target), nil
+}
+
+// migrateSymlink migrates the HCP symbolic link in entry as set by
+// --symlinks.
+func migrateSymlink(ctx context.Context, entry Entry) error {
+	if symlinkPolicy != symlinkFollow && symlinkPolicy != symlinkLink {
+		logMsg(fmt.Sprintf("symbolic link %s not migrated, see --symlinks", entry.ObjectPath))
+		return errObjectSkipped
+	}
+	target := entry.SymlinkTarget
+	if target == "" {
+		var err error
+		if target, err = hcp.ReadSymlink(ctx, entry.ObjectPath); err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(target, "/rest/") {
+		return fmt.Errorf("target %s of symbolic link is outside of the namespace", target)
+	}
+	if symlinkPolicy == symlinkLink {
+		return migrateLink(ctx, entry, target)
+	}
+	r, oi, err := getObject(Entry{}, target, "")
+	if err != nil {
+		return fmt.Errorf("target %s: %w", target, err)
+	}
+	defer r.Close()
+	// the link is named by its own path and the attributes of its target
+	if oi.Key, err = objectKey(entry, oi.Size, oi.Metadata); err != nil {
+		return err
+	}
+	if dryRun {
+		logMsg(migrateMsg(entry.ObjectPath+" -> "+target, oi.Key))
+		return nil
+	}
+	release, err := claimKey(entry.ObjectPath, oi.Key)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if exists, err := existingObject(ctx, entry.ObjectPath, oi.Key); err != nil || exists {
+		return err
+	}
+	oi.UserMetadata = miniogo.StringMap{sourcePathMeta: entry.ObjectPath}
+	return uploadObject(ctx, target, r, oi)
+}
+
+// migrateLink migrates the HCP symbolic link in entry to target as an
+// empty object naming the MinIO object of the target in its metadata.
+func migrateLink(ctx context.Context, entry Entry, target string) error {
+	key, err := objectKey(entry, listedSize(entry), nil)
+	if err != nil {
+		return err
+	}
+	targetKey, err := objectKey(Entry{ObjectPath: target}, unknownSize, nil)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		logMsg(migrateMsg(entry.ObjectPath+" -> "+target, key))
+		return nil
+	}
+	release, err := claimKey(entry.ObjectPath, key)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if exists, err := existingObject(ctx, entry.ObjectPath, key); err != nil || exists {
+		return err
+	}
+	_, err = minioClient.PutObject(ctx, minioBucket, key, bytes.NewReader(nil), 0, miniogo.PutObjectOptions{
+		UserMetadata: map[string]string{
+			symlinkTargetMeta: targetKey,
+			sourcePathMeta:    entry.ObjectPath,
+		},
+		Internal: miniogo.AdvancedPutOptions{
+			SourceMTime: entry.changeTime(),
+		},
+	})
+	return err
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/x509"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/minio/minio/pkg/console"
@@ -45,6 +49,58 @@ func logDMsg(msg string, err error) {
 		fmt.Println(msg, " :", err)
 	}
 }
+
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+	retryAfterMax  = 5 * time.Minute
+)
+
+// backoff returns the time to wait before retry attempt n, counting from
+// 1. It grows exponentially from retryBaseDelay up to retryMaxDelay and
+// is jittered so that workers failing together do not retry together.
+func backoff(n int) time.Duration {
+	d := retryMaxDelay
+	if n < 16 {
+		if e := retryBaseDelay << uint(n-1); e < d {
+			d = e
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// withRetry calls fn until it succeeds, returns an error that is not
+// retryable or has been retried maxRetries times.
+func withRetry(ctx context.Context, maxRetries int, what string, fn func() error) error {
+	return withRetryIf(ctx, maxRetries, what, isRetryableError, fn)
+}
+
+// withRetryIf is withRetry with retryable telling which errors to retry.
+// HCP asking to be retried later with Retry-After is waited for, up to
+// retryAfterMax.
+func withRetryIf(ctx context.Context, maxRetries int, what string, retryable func(error) bool, fn func() error) error {
+	for n := 1; ; n++ {
+		err := fn()
+		if err == nil || n > maxRetries || !retryable(err) {
+			return err
+		}
+		d := backoff(n)
+		var he *hcpError
+		if errors.As(err, &he) && he.retryAfter > d {
+			d = he.retryAfter
+			if d > retryAfterMax {
+				d = retryAfterMax
+			}
+		}
+		logDMsg(fmt.Sprintf("retrying %s in %s, attempt %d of %d", what, d, n, maxRetries), err)
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 func trace(rq *http.Request, rs *http.Response) string {
 	var b = &strings.Builder{}
 
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	testCases := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{1, retryBaseDelay / 2, retryBaseDelay},
+		{2, retryBaseDelay, 2 * retryBaseDelay},
+		{3, 2 * retryBaseDelay, 4 * retryBaseDelay},
+		{5, 8 * retryBaseDelay, 16 * retryBaseDelay},
+		// capped at retryMaxDelay
+		{6, retryMaxDelay / 2, retryMaxDelay},
+		{15, retryMaxDelay / 2, retryMaxDelay},
+		{16, retryMaxDelay / 2, retryMaxDelay},
+		{100, retryMaxDelay / 2, retryMaxDelay},
+	}
+	for i, testCase := range testCases {
+		// jittered, so sampled
+		for j := 0; j < 100; j++ {
+			if d := backoff(testCase.attempt); d < testCase.min || d > testCase.max {
+				t.Fatalf("Test %d: backoff(%d) = %s, want between %s and %s", i+1, testCase.attempt, d, testCase.min, testCase.max)
+			}
+		}
+	}
+}
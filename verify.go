@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/minio/cli"
+)
+
+const (
+	hcpHashHeader = "X-HCP-Hash"
+
+	// verifiedHashMeta is the user metadata holding the HCP hash an
+	// object was verified against, as "<scheme> <hex digest>".
+	verifiedHashMeta = "hcp-verified-hash"
+)
+
+var verifyFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "verify",
+		Usage: "hash the content of each object on its way to MinIO and reject the upload if it differs from the hash reported by HCP",
+	},
+}
+
+var verifyContent bool
+
+// newHash returns the hash of an HCP hash scheme.
+func newHash(scheme string) (hash.Hash, error) {
+	switch strings.ToUpper(scheme) {
+	case "MD5":
+		return md5.New(), nil
+	case "SHA-1":
+		return sha1.New(), nil
+	case "SHA-256":
+		return sha256.New(), nil
+	case "SHA-384":
+		return sha512.New384(), nil
+	case "SHA-512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("hash scheme %s is not supported for verification", scheme)
+}
+
+// parseHCPHash returns the scheme, a new hash and the digest of a hash
+// reported by HCP as "<scheme> <hex digest>" like X-HCP-Hash.
+func parseHCPHash(hcpHash string) (scheme string, h hash.Hash, digest []byte, err error) {
+	fields := strings.Fields(hcpHash)
+	if len(fields) != 2 {
+		return "", nil, nil, fmt.Errorf("no valid hash reported by HCP to verify against: %q", hcpHash)
+	}
+	if h, err = newHash(fields[0]); err != nil {
+		return "", nil, nil, err
+	}
+	if digest, err = hex.DecodeString(fields[1]); err != nil {
+		return "", nil, nil, fmt.Errorf("invalid hash %q reported by HCP: %w", hcpHash, err)
+	}
+	return strings.ToUpper(fields[0]), h, digest, nil
+}
+
+// verifyingReader hashes the content of an HCP object as it is read and,
+// if the content does not match the hash HCP reports for it, holds back
+// its last byte and fails the read, so that the upload reading it is
+// rejected. Readers like io.ReadFull drop an error returned along with
+// the bytes they asked for, so failing the read alone is not enough.
+type verifyingReader struct {
+	r      io.Reader
+	h      hash.Hash
+	scheme string
+	want   []byte
+	size   int64
+	n      int64
+	done   bool
+	err    error
+}
+
+// newVerifyingReader returns a reader verifying the size bytes read from
+// r against hcpHash.
+func newVerifyingReader(r io.Reader, size int64, hcpHash string) (*verifyingReader, error) {
+	scheme, h, want, err := parseHCPHash(hcpHash)
+	if err != nil {
+		return nil, err
+	}
+	v := &verifyingReader{r: r, h: h, scheme: scheme, want: want, size: size}
+	if size == 0 {
+		return v, v.verify()
+	}
+	return v, nil
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	v.n += int64(n)
+	switch {
+	case v.n > v.size:
+		v.err = fmt.Errorf("read %d bytes, more than the %d reported by HCP", v.n, v.size)
+		return n, v.err
+	case v.n == v.size && !v.done:
+		if verr := v.verify(); verr != nil {
+			return n - 1, verr
+		}
+	case err == io.EOF && v.n < v.size:
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// verify compares the hash of the content read so far with the hash of
+// HCP.
+func (v *verifyingReader) verify() error {
+	v.done = true
+	if got := v.h.Sum(nil); !bytes.Equal(got, v.want) {
+		v.err = fmt.Errorf("content %s %X does not match the %X reported by HCP", v.scheme, got, v.want)
+	}
+	return v.err
+}
+
+// String returns the hash verified against as "<scheme> <hex digest>".
+func (v *verifyingReader) String() string {
+	return v.scheme + " " + hex.EncodeToString(v.want)
+}
+
+// verified returns an error if not all the content was read and
+// verified.
+func (v *verifyingReader) verified() error {
+	if !v.done {
+		return fmt.Errorf("only %d of %d bytes were verified", v.n, v.size)
+	}
+	return v.err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+func TestVerifyingReader(t *testing.T) {
+	hello := []byte("hello")
+	sum := sha256.Sum256(hello)
+	md5sum := md5.Sum(hello)
+	good := "SHA-256 " + hex.EncodeToString(sum[:])
+	testCases := []struct {
+		content  []byte
+		size     int64
+		hcpHash  string
+		readErr  bool // the read of the content fails
+		verified bool // the content is verified
+	}{
+		{hello, 5, good, false, true},
+		{hello, 5, "sha-256 " + hex.EncodeToString(sum[:]), false, true},
+		{hello, 5, "MD5 " + hex.EncodeToString(md5sum[:]), false, true},
+		// mismatch
+		{[]byte("hellO"), 5, good, true, false},
+		{hello, 5, "MD5 " + hex.EncodeToString(sum[:16]), true, false},
+		// short read
+		{hello[:3], 5, good, true, false},
+		{nil, 5, good, true, false},
+		// long read
+		{append(hello, '!'), 5, good, true, false},
+		// empty objects are verified up front
+		{nil, 0, "SHA-256 e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", false, true},
+	}
+	for i, testCase := range testCases {
+		for _, r := range []io.Reader{bytes.NewReader(testCase.content), iotest.OneByteReader(bytes.NewReader(testCase.content))} {
+			vr, err := newVerifyingReader(r, testCase.size, testCase.hcpHash)
+			if err != nil && testCase.size > 0 {
+				t.Fatalf("Test %d: unexpected error %v", i+1, err)
+			}
+			b, err := ioutil.ReadAll(vr)
+			if (err != nil) != testCase.readErr {
+				t.Errorf("Test %d: read error %v, want error %t", i+1, err, testCase.readErr)
+			}
+			// the last byte of content that does not match is held back
+			if err != nil && int64(len(testCase.content)) == testCase.size && int64(len(b)) == testCase.size {
+				t.Errorf("Test %d: read all %d bytes of mismatching content", i+1, len(b))
+			}
+			if err = vr.verified(); (err == nil) != testCase.verified {
+				t.Errorf("Test %d: verified() = %v, want verified %t", i+1, err, testCase.verified)
+			}
+		}
+	}
+}
+
+func TestVerifyingReaderReadFull(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello"))
+	vr, err := newVerifyingReader(bytes.NewReader([]byte("hellO")), 5, "SHA-256 "+hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// io.ReadFull drops an error returned along with all the bytes asked
+	// for, the mismatch must still come out of it
+	if _, err = io.ReadFull(vr, make([]byte, 5)); err == nil {
+		t.Fatal("expected the mismatch to fail io.ReadFull")
+	}
+	if vr.err == nil {
+		t.Fatal("expected the mismatch to be recorded")
+	}
+}
+
+func TestParseHCPHash(t *testing.T) {
+	testCases := []struct {
+		hcpHash string
+		scheme  string
+		success bool
+	}{
+		{"SHA-256 2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824", "SHA-256", true},
+		{"sha-1 aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", "SHA-1", true},
+		{"SHA-512 00", "SHA-512", true},
+		{"", "", false},
+		{"SHA-256", "", false},
+		{"CRC32 00", "", false},
+		{"SHA-256 xyz", "", false},
+	}
+	for i, testCase := range testCases {
+		scheme, _, _, err := parseHCPHash(testCase.hcpHash)
+		if (err == nil) != testCase.success || scheme != testCase.scheme {
+			t.Errorf("Test %d: parseHCPHash(%q) = %q, %v", i+1, testCase.hcpHash, scheme, err)
+		}
+	}
+}
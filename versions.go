@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	miniogo "github.com/minio/minio-go/v7"
+	"github.com/minio/minio/pkg/console"
+)
+
+// Versions represents the version listing of an object
+type Versions struct {
+	XMLName           xml.Name `xml:"versions"`
+	Path              string   `xml:"path,attr,omitempty"`
+	UTF8Path          string   `xml:"utf8Path,attr,omitempty"`
+	ParentDir         string   `xml:"parentDir,attr,omitempty"`
+	UTF8ParentDir     string   `xml:"utf8ParentDir,attr,omitempty"`
+	Deleted           bool     `xml:"deleted,attr"`
+	ShowDeleted       bool     `xml:"showDeleted,attr"`
+	NamespaceName     string   `xml:"namespaceName,attr,omitempty"`
+	UTF8NamespaceName string   `xml:"utf8NamespaceName,attr,omitempty"`
+	Entries           []Entry  `xml:"entry"`
+}
+
+// ListVersions returns the versions of object held by HCP, oldest first.
+func (hcp *hcpBackend) ListVersions(ctx context.Context, object string) ([]Entry, error) {
+	u, err := url.Parse(namespaceURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = object
+	u.RawQuery = url.Values{"version": []string{"list"}}.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", authToken)
+	req.Host = hostHeader
+	resp, err := hcp.Client().Do(req)
+	if debugFlag {
+		console.Println(trace(req, resp))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closeResponse(resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, newHCPError(resp)
+	}
+	var versions Versions
+	if err = xml.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return nil, fmt.Errorf("error decoding versions of %s: %w", object, err)
+	}
+	for i := range versions.Entries {
+		versions.Entries[i].ObjectPath = object
+		if err = versions.Entries[i].decodeChangeTime(); err != nil {
+			return nil, fmt.Errorf("error decoding versions of %s: %w", object, err)
+		}
+	}
+	sortVersions(versions.Entries)
+	return versions.Entries, nil
+}
+
+// sortVersions orders versions oldest first. HCP version ids grow over
+// time, so they order versions ingested within the same second.
+func sortVersions(versions []Entry) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		vi, vj := versions[i], versions[j]
+		if vi.IngestTime != vj.IngestTime {
+			return vi.IngestTime < vj.IngestTime
+		}
+		if len(vi.Version) != len(vj.Version) {
+			return len(vi.Version) < len(vj.Version)
+		}
+		return vi.Version < vj.Version
+	})
+}
+
+// sourceVersionMeta is the user metadata naming the HCP version a MinIO
+// object version was migrated from.
+const sourceVersionMeta = "hcp-source-version"
+
+// migratedVersions returns the number of the HCP versions of object,
+// oldest first, already migrated to key by a previous run. Versions are
+// replayed oldest first, so they are those up to the one the latest MinIO
+// version of key was migrated from, none if it was not migrated from any.
+// An error is returned if key was migrated from another HCP object.
+func migratedVersions(ctx context.Context, object, key string, versions []Entry) (int, error) {
+	oi, err := minioClient.StatObject(ctx, minioBucket, key, miniogo.StatObjectOptions{})
+	if err != nil {
+		if miniogo.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if source := oi.Metadata.Get("X-Amz-Meta-" + sourcePathMeta); source != "" && source != object {
+		return 0, fmt.Errorf("MinIO object %s was migrated from %s, which is mapped to the same name", key, source)
+	}
+	source := oi.Metadata.Get("X-Amz-Meta-" + sourceVersionMeta)
+	if source == "" {
+		return 0, nil
+	}
+	for i, v := range versions {
+		if v.Version == source {
+			return i + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// migrateObjectVersions replays every version of the object in entry
+// selected by the filters into the versioned MinIO bucket, oldest first,
+// keeping the original modification time of each version.
+func migrateObjectVersions(ctx context.Context, entry Entry) error {
+	object := entry.ObjectPath
+	versions := entry.Versions
+	if len(versions) == 0 {
+		var err error
+		versions, err = hcp.ListVersions(ctx, object)
+		if err != nil {
+			return err
+		}
+	} else {
+		sortVersions(versions)
+	}
+	named := entry
+	if len(versions) > 0 {
+		// all versions are named after the latest one
+		named = versions[len(versions)-1]
+		named.ObjectPath = object
+	}
+	key, err := objectKey(named, listedSize(named), nil)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		for _, v := range versions {
+			logMsg(migrateMsg(fmt.Sprintf("%s?version=%s", object, v.Version), key))
+		}
+		return nil
+	}
+	release, err := claimKey(object, key)
+	if err != nil {
+		return err
+	}
+	defer release()
+	migrated, err := migratedVersions(ctx, object, key, versions)
+	if err != nil {
+		return err
+	}
+	if migrated >= len(versions) {
+		logDMsg(fmt.Sprintf("all %d versions of %s already exist on MinIO, not migrated", len(versions), key), nil)
+		return nil
+	}
+	for i, v := range versions[migrated:] {
+		v.ObjectPath = object
+		if v.EntryType != "" && !filter.matchEntry(v) {
+			logDMsg(fmt.Sprintf("version %s of %s not selected by filters, not migrated", v.Version, object), nil)
+			continue
+		}
+		r, oi, err := getObject(v, object, v.Version)
+		if err != nil {
+			return fmt.Errorf("version %s: %w", v.Version, err)
+		}
+		if !filter.matchObjectInfo(oi) {
+			r.Close()
+			logDMsg(fmt.Sprintf("version %s of %s not selected by filters, not migrated", v.Version, object), nil)
+			continue
+		}
+		oi.Key = key
+		if oi.UserMetadata == nil {
+			oi.UserMetadata = make(miniogo.StringMap)
+		}
+		oi.UserMetadata[sourcePathMeta] = object
+		oi.UserMetadata[sourceVersionMeta] = v.Version
+		// annotations are only kept with the latest version
+		if migrated+i == len(versions)-1 {
+			err = uploadWithAnnotations(ctx, r, oi, entry)
+		} else {
+			err = uploadObject(ctx, object, r, oi)
+		}
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("version %s: %w", v.Version, err)
+		}
+	}
+	return nil
+}